@@ -0,0 +1,68 @@
+package main
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// Auto-saving writes buffers through write_file like a manual save, but only
+// when that can happen without asking anything: buffers that need a name,
+// a missing directory made, or an external change resolved are left alone.
+
+var (
+	// When the last key was pressed, to tell when the editor is idle
+	lastInput = time.Now()
+
+	// The edit an auto-save last failed at, so it isn't retried
+	// until there's something new to save
+	autoSaveFailedVersion = -1
+)
+
+// can_auto_save reports whether the buffer can be saved without asking anything
+func can_auto_save() bool {
+	path := filename + fileExtension
+	switch {
+	case !modified, pipeMode, path == "", buffer_editable() != nil:
+		return false
+	case promptActive, autoSaveFailedVersion == bufferVersion:
+		return false
+	case !swapChecked:
+		// Not shown yet, and saving could bring up its swap file's recovery prompt
+		return false
+	case !dir_exists(filepath.Dir(path)):
+		return false
+	}
+	_, changed := disk_changed()
+	return !changed
+}
+
+func auto_save() {
+	if !can_auto_save() {
+		return
+	}
+	if write_file(filename, fileExtension) != nil {
+		autoSaveFailedVersion = bufferVersion
+	}
+}
+
+// auto_save_all saves every buffer that can be, coming back to the current one.
+// The others aren't shown, so nothing about them is asked along the way.
+func auto_save_all() {
+	current := currentBuffer
+	for _, i := range unsaved_buffers() {
+		if i != currentBuffer {
+			use_buffer(i)
+		}
+		auto_save()
+	}
+	if currentBuffer != current {
+		use_buffer(current)
+	}
+}
+
+// auto_save_idle saves once there's been no typing for AUTO_SAVE_IDLE
+func auto_save_idle() {
+	if AUTO_SAVE_IDLE > 0 && time.Since(lastInput) >= AUTO_SAVE_IDLE {
+		auto_save()
+	}
+}
@@ -1,375 +1,464 @@
-package main
-
-import (
-	"os"
-
-	termbox "github.com/nsf/termbox-go"
-)
-
-func get_key() termbox.Event {
-	// Function to detect and grab keypresses,
-	// handled by process_key in keybinds.go
-	var keyEvent termbox.Event
-
-	switch event := termbox.PollEvent(); event.Type {
-	case termbox.EventKey:
-		keyEvent = event
-	case termbox.EventError:
-		panic(event.Err)
-	}
-	return keyEvent
-}
-
-func process_key() {
-	keyEvent := get_key()
-	prevRow := currentRow
-
-	if mode == 0 && jumpPending {
-		if handle_jump_digit(keyEvent.Ch) {
-			if currentRow != prevRow {
-				mark_viewport_dirty()
-			}
-			return
-		}
-	}
-
-	// Binds that happen regardless of mode
-	switch keyEvent.Key {
-
-	// Controls
-	case TOGGLE_MODE_KEY:
-		switch_mode("Toggle")
-
-	case termbox.KeyCtrlS:
-		write_file(filename, fileExtension)
-
-	// Special Key Navigation
-	case termbox.KeyArrowUp:
-		if currentRow != 0 {
-			currentRow--
-		}
-
-	case termbox.KeyArrowDown:
-		if currentRow < len(textBuffer)-1 {
-			currentRow++
-		}
-
-	case termbox.KeyArrowLeft:
-		if currentCol != 0 {
-			currentCol--
-		} else if currentRow > 0 {
-			currentRow--
-			currentCol = len(textBuffer[currentRow])
-		}
-
-	case termbox.KeyArrowRight:
-		if currentCol < len(textBuffer[currentRow]) {
-			currentCol++
-		} else if currentRow < len(textBuffer)-1 {
-			currentRow++
-			currentCol = 0
-		}
-
-	case PAGE_UP:
-		page_up()
-
-	case PAGE_DOWN:
-		page_down()
-
-	case START_OF_LINE:
-		// move cursor to first non-whitespace character of line
-		currentLine := textBuffer[currentRow]
-		currentCol = len(currentLine)
-		for i, ch := range currentLine {
-			if ch != ' ' && ch != '\t' {
-				currentCol = i
-				break
-			}
-		}
-
-	case END_OF_LINE:
-		// move cursor to last character of line (even if it it is whitespace)
-		currentCol = len(textBuffer[currentRow])
-	}
-
-	// First, check the mode.
-	switch mode {
-	// case [VIEW] mode
-	case 0:
-		if keyEvent.Ch != 0 {
-			// Printable Character Pressed
-			switch keyEvent.Ch {
-
-			// Controls
-			// Exit program with saving
-			case QUIT_SAVE:
-				write_file(filename, fileExtension)
-				termbox.Close()
-				os.Exit(0)
-
-			// Exit program without saving
-			case QUIT_NOSAVE:
-				termbox.Close()
-				os.Exit(0)
-
-			// Navigation
-			case CURSOR_LEFT:
-				if currentCol != 0 {
-					currentCol--
-				} else if currentRow > 0 {
-					currentRow--
-					currentCol = len(textBuffer[currentRow])
-				}
-
-			case CURSOR_DOWN:
-				if currentRow < len(textBuffer)-1 {
-					currentRow++
-				}
-
-			case CURSOR_UP:
-				if currentRow != 0 {
-					currentRow--
-				}
-
-			case CURSOR_RIGHT:
-				if currentCol < len(textBuffer[currentRow]) {
-					currentCol++
-				} else if currentRow < len(textBuffer)-1 {
-					currentRow++
-					currentCol = 0
-				}
-
-			case JUMP_UP:
-				jump_up()
-
-			case JUMP_DOWN:
-				jump_down()
-
-			// ---------- Copy/Paste ----------
-
-			// Symbol Controls
-			case COPY_SYMBOL_KEY:
-				copy_symbol()
-			case CUT_SYMBOL_KEY:
-				cut_symbol()
-			case PASTE_SYMBOL_KEY:
-				paste_symbol()
-			case DEL_SYMBOL_KEY:
-				delete_symbol()
-
-			// Line Controls
-			case COPY_LINE_KEY:
-				copy_line()
-			case CUT_LINE_KEY:
-				cut_line()
-			case PASTE_LINE_KEY:
-				paste_line()
-			case DEL_LINE_KEY:
-				delete_line()
-
-			// Block Controls
-			case COPY_BLOCK_KEY:
-				copy_block()
-			case CUT_BLOCK_KEY:
-				cut_block()
-			case PASTE_BLOCK_KEY:
-				paste_block()
-			case DEL_BLOCK_KEY:
-				delete_block()
-
-			// Save state (push state onto stack)
-			case MANUAL_SAVE_STATE:
-				push_state()
-
-			// Rollback state (pop state from stack)
-			case ROLLBACK_STATE:
-				pull_state()
-			}
-
-			// Bound Cursor within buffer
-			if currentCol > len(textBuffer[currentRow]) {
-				currentCol = len(textBuffer[currentRow])
-			}
-		} else {
-			switch keyEvent.Key {
-			}
-		}
-
-	// case [EDIT] mode
-	case 1:
-
-		// If character is printable, insert it
-		if keyEvent.Ch != 0 {
-			insert_rune(keyEvent)
-		} else {
-			switch keyEvent.Key {
-
-			case termbox.KeySpace:
-				insert_rune(keyEvent)
-			case termbox.KeyTab:
-				for i := 0; i < TAB_WIDTH; i++ {
-					insert_rune(keyEvent)
-				}
-
-			case termbox.KeyBackspace:
-				delete_rune(keyEvent)
-			case termbox.KeyBackspace2:
-				delete_rune(keyEvent)
-			case termbox.KeyDelete:
-				delete_rune(keyEvent)
-
-			case termbox.KeyEnter:
-				insert_line()
-
-			}
-		}
-	}
-
-	if currentRow != prevRow {
-		mark_viewport_dirty()
-	}
-}
-
-func insert_line() {
-
-	// Create a new line, and wrap the remainder of the current line onto the next one
-	currentLine := textBuffer[currentRow]
-	if currentCol > len(currentLine) {
-		currentCol = len(currentLine)
-	}
-
-	indentLen := 0
-	for indentLen < currentCol {
-		ch := currentLine[indentLen]
-		if ch != ' ' && ch != '\t' {
-			break
-		}
-		indentLen++
-	}
-
-	extraIndent := 0
-	if currentCol == len(currentLine) && len(currentLine) > 0 {
-		switch currentLine[len(currentLine)-1] {
-		case '(', '{', '[', ':':
-			extraIndent = TAB_WIDTH
-		}
-	}
-
-	newIndentLen := indentLen + extraIndent
-	newLine := make([]rune, newIndentLen+len(currentLine)-currentCol)
-	copy(newLine[:indentLen], currentLine[:indentLen])
-	if extraIndent > 0 {
-		copy(newLine[indentLen:newIndentLen], tabExpansion)
-	}
-	copy(newLine[newIndentLen:], currentLine[currentCol:])
-
-	textBuffer[currentRow] = currentLine[:currentCol]
-	textBuffer = append(textBuffer[:currentRow+1], append([][]rune{newLine}, textBuffer[currentRow+1:]...)...)
-
-	currentRow++
-	currentCol = newIndentLen
-
-	mark_viewport_dirty()
-	mark_line_dirty(currentRow)
-}
-
-func insert_rune(event termbox.Event) {
-	rowBuffer := make([]rune, len(textBuffer[currentRow])+1)
-
-	// Populate line buffer with currentRow contents
-	// from the start of row, to the cursor
-	copy(rowBuffer[:currentCol], textBuffer[currentRow][:currentCol])
-
-	currentRune := &rowBuffer[currentCol]
-
-	switch event.Key {
-	case termbox.KeySpace:
-		*currentRune = rune(' ')
-	case termbox.KeyTab:
-		*currentRune = rune('\t')
-	default:
-		*currentRune = rune(event.Ch)
-	}
-
-	// Finish populating line buffer with currentRow contents
-	// from the cursor to the end of the line
-	copy(rowBuffer[currentCol+1:], textBuffer[currentRow][currentCol:])
-
-	textBuffer[currentRow] = rowBuffer
-	currentCol++
-
-	mark_line_dirty(currentRow)
-}
-
-func delete_rune(event termbox.Event) {
-	lineShifted := false
-
-	switch event.Key {
-
-	// delete the character to the left
-	case termbox.KeyBackspace, termbox.KeyBackspace2:
-
-		// If not deleting a newline character
-		if currentCol > 0 {
-			currentCol--
-
-			deleteLine := make([]rune, len(textBuffer[currentRow])-1)
-			copy(deleteLine[:currentCol], textBuffer[currentRow][:currentCol])
-			copy(deleteLine[currentCol:], textBuffer[currentRow][currentCol+1:])
-			textBuffer[currentRow] = deleteLine
-
-		} else if currentRow > 0 {
-
-			// If deleting a newline character, wrap the text onto the previous line
-			wrapText := make([]rune, len(textBuffer[currentRow]))
-			copy(wrapText, textBuffer[currentRow][currentCol:])
-
-			prevLineLen := len(textBuffer[currentRow-1])
-			appendLine := make([]rune, len(textBuffer[currentRow-1])+len(wrapText))
-			copy(appendLine[:len(textBuffer[currentRow-1])], textBuffer[currentRow-1])
-			copy(appendLine[len(textBuffer[currentRow-1]):], wrapText)
-
-			textBuffer[currentRow-1] = appendLine
-			textBuffer = append(textBuffer[:currentRow], textBuffer[currentRow+1:]...)
-
-			currentRow--
-			currentCol = prevLineLen
-			lineShifted = true
-
-		}
-
-	// Delete the character to the right
-	case termbox.KeyDelete:
-
-		// If not deleting a newline character
-		if currentCol < len(textBuffer[currentRow]) {
-
-			deleteLine := make([]rune, len(textBuffer[currentRow])-1)
-			copy(deleteLine[:currentCol], textBuffer[currentRow][:currentCol+1])
-			copy(deleteLine[currentCol:], textBuffer[currentRow][currentCol+1:])
-			textBuffer[currentRow] = deleteLine
-
-		} else if currentRow < len(textBuffer)-1 {
-
-			// If deleting a newline character, wrap the next line on top of the currentRow
-			wrapText := textBuffer[currentRow+1]
-
-			currentLineLen := len(textBuffer[currentRow])
-			appendLine := make([]rune, len(textBuffer[currentRow])+len(wrapText))
-			copy(appendLine[:len(textBuffer[currentRow])], textBuffer[currentRow])
-			copy(appendLine[len(textBuffer[currentRow]):], wrapText)
-
-			textBuffer[currentRow] = appendLine
-			textBuffer = append(textBuffer[:currentRow+1], textBuffer[currentRow+2:]...)
-
-			currentCol = currentLineLen
-			lineShifted = true
-
-		}
-	}
-
-	if lineShifted {
-		mark_viewport_dirty()
-	}
-	mark_line_dirty(currentRow)
-}
+package main
+
+import (
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// View mode keys that change the buffer, refused when it can't be edited
+var editKeys = map[rune]bool{
+	CUT_SYMBOL_KEY:   true,
+	PASTE_SYMBOL_KEY: true,
+	DEL_SYMBOL_KEY:   true,
+	CUT_LINE_KEY:     true,
+	PASTE_LINE_KEY:   true,
+	DEL_LINE_KEY:     true,
+	CUT_BLOCK_KEY:    true,
+	PASTE_BLOCK_KEY:  true,
+	DEL_BLOCK_KEY:    true,
+
+	INDENT_BLOCK_KEY:  true,
+	OUTDENT_BLOCK_KEY: true,
+
+	CUT_OPERATOR_KEY:     true,
+	CHANGE_OPERATOR_KEY:  true,
+	DEL_OPERATOR_KEY:     true,
+	INDENT_OPERATOR_KEY:  true,
+	OUTDENT_OPERATOR_KEY: true,
+	CASE_OPERATOR_KEY:    true,
+
+	MANUAL_SAVE_STATE: true,
+	ROLLBACK_STATE:    true,
+}
+
+func process_key(keyEvent termbox.Event) {
+	if keyEvent.Type == termbox.EventError {
+		panic(keyEvent.Err)
+	}
+	prevRow := currentRow
+
+	if keyEvent.Type == termbox.EventResize {
+		resize(keyEvent.Width, keyEvent.Height)
+		return
+	}
+	if keyEvent.Type != termbox.EventKey {
+		return
+	}
+	lastInput = time.Now()
+
+	if focusIn, ok := read_focus_event(keyEvent); ok {
+		handle_focus(focusIn)
+		return
+	}
+
+	if pagerActive {
+		handle_pager_key(keyEvent)
+		return
+	}
+
+	if promptActive {
+		handle_prompt_key(keyEvent)
+		return
+	}
+
+	if commandActive {
+		handle_command_key(keyEvent)
+		if currentRow != prevRow {
+			mark_viewport_dirty()
+		}
+		return
+	}
+
+	if hexActive && handle_hex_key(keyEvent) {
+		return
+	}
+
+	if mode == 0 && operatorPending {
+		handle_operator_key(keyEvent)
+		if currentRow != prevRow {
+			mark_viewport_dirty()
+		}
+		return
+	}
+
+	if mode == 0 && markPending != 0 {
+		handle_mark_key(keyEvent.Ch)
+		if currentRow != prevRow {
+			mark_viewport_dirty()
+		}
+		return
+	}
+
+	if mode == 0 && countPending {
+		if handle_count_digit(keyEvent.Ch) {
+			return
+		}
+	}
+
+	if mode == 0 && jumpPending {
+		if handle_jump_digit(keyEvent.Ch) {
+			if currentRow != prevRow {
+				mark_viewport_dirty()
+			}
+			return
+		}
+	}
+
+	// Binds that happen regardless of mode
+	switch keyEvent.Key {
+
+	// Controls
+	case TOGGLE_MODE_KEY:
+		switch_mode("Toggle")
+
+	case termbox.KeyCtrlS:
+		write_file(filename, fileExtension)
+
+	case SUSPEND_KEY:
+		suspend()
+
+	// Special Key Navigation
+	case termbox.KeyArrowUp:
+		move_cursor(motions[CURSOR_UP], 0)
+
+	case termbox.KeyArrowDown:
+		move_cursor(motions[CURSOR_DOWN], 0)
+
+	case termbox.KeyArrowLeft:
+		if currentCol != 0 {
+			currentCol--
+		} else if currentRow > 0 {
+			currentRow--
+			currentCol = len(textBuffer[currentRow])
+		}
+
+	case termbox.KeyArrowRight:
+		if currentCol < len(textBuffer[currentRow]) {
+			currentCol++
+		} else if currentRow < len(textBuffer)-1 {
+			currentRow++
+			currentCol = 0
+		}
+
+	case PAGE_UP:
+		page_up()
+
+	case PAGE_DOWN:
+		page_down()
+
+	case START_OF_LINE:
+		// move cursor to first non-whitespace character of line
+		currentLine := textBuffer[currentRow]
+		currentCol = len(currentLine)
+		for i, ch := range currentLine {
+			if ch != ' ' && ch != '\t' {
+				currentCol = i
+				break
+			}
+		}
+
+	case END_OF_LINE:
+		// move cursor to last character of line (even if it it is whitespace)
+		currentCol = len(textBuffer[currentRow])
+	}
+
+	// First, check the mode.
+	switch mode {
+	// case [VIEW] mode
+	case 0:
+		if keyEvent.Ch != 0 {
+			// Printable Character Pressed
+			count := take_count()
+			if editKeys[keyEvent.Ch] && !check_editable() {
+				return
+			}
+			switch keyEvent.Ch {
+
+			// Controls
+			case COMMAND_KEY:
+				open_command_line()
+
+			// Exit program with saving
+			case QUIT_SAVE:
+				save_and_quit()
+
+			// Exit program without saving, asking first about unsaved changes
+			case QUIT_NOSAVE:
+				quit_editor()
+			case FORCE_QUIT:
+				discard_and_quit()
+
+			// Navigation
+			// (other motions are looked up in motions.go)
+			case JUMP_UP, JUMP_DOWN:
+				if count > 0 {
+					move_cursor(motions[keyEvent.Ch], count)
+				} else if keyEvent.Ch == JUMP_UP {
+					jump_up()
+				} else {
+					jump_down()
+				}
+
+			case COUNT_KEY:
+				start_count()
+
+			// ---------- Copy/Paste ----------
+
+			// Symbol Controls
+			case COPY_SYMBOL_KEY:
+				copy_symbol()
+			case CUT_SYMBOL_KEY:
+				cut_symbol()
+			case PASTE_SYMBOL_KEY:
+				paste_symbol()
+			case DEL_SYMBOL_KEY:
+				delete_symbol()
+
+			// Line Controls
+			case COPY_LINE_KEY:
+				copy_line()
+			case CUT_LINE_KEY:
+				cut_line()
+			case PASTE_LINE_KEY:
+				paste_line()
+			case DEL_LINE_KEY:
+				delete_line()
+
+			// Block Controls
+			case COPY_BLOCK_KEY:
+				copy_block()
+			case CUT_BLOCK_KEY:
+				cut_block()
+			case PASTE_BLOCK_KEY:
+				paste_block()
+			case DEL_BLOCK_KEY:
+				delete_block()
+			case INDENT_BLOCK_KEY:
+				indent_block()
+			case OUTDENT_BLOCK_KEY:
+				outdent_block()
+
+			// Operators, which wait for a motion or text object
+			case COPY_OPERATOR_KEY, CUT_OPERATOR_KEY, CHANGE_OPERATOR_KEY, DEL_OPERATOR_KEY,
+				INDENT_OPERATOR_KEY, OUTDENT_OPERATOR_KEY, CASE_OPERATOR_KEY:
+				start_operator(keyEvent.Ch, count)
+
+			// Marks
+			case SET_MARK_KEY, GOTO_MARK_LINE_KEY, GOTO_MARK_KEY:
+				start_mark(keyEvent.Ch)
+
+			// Jump back and forward through the change list
+			case CHANGE_BACK_KEY:
+				change_back()
+			case CHANGE_FORWARD_KEY:
+				change_forward()
+
+			// Save state (push state onto stack)
+			case MANUAL_SAVE_STATE:
+				push_state()
+
+			// Rollback state (pop state from stack)
+			case ROLLBACK_STATE:
+				pull_state()
+
+			default:
+				if m, ok := motions[keyEvent.Ch]; ok {
+					move_cursor(m, count)
+				}
+			}
+
+			// Bound Cursor within buffer
+			if currentCol > len(textBuffer[currentRow]) {
+				currentCol = len(textBuffer[currentRow])
+			}
+		} else {
+			switch keyEvent.Key {
+
+			// Jump back and forward through the jump list
+			case JUMP_BACK_KEY:
+				jump_back()
+			case JUMP_FORWARD_KEY:
+				jump_forward()
+			}
+		}
+
+	// case [EDIT] mode
+	case 1:
+
+		// If character is printable, insert it
+		if keyEvent.Ch != 0 {
+			insert_rune(keyEvent)
+		} else {
+			switch keyEvent.Key {
+
+			case termbox.KeySpace:
+				insert_rune(keyEvent)
+			case termbox.KeyTab:
+				insert_indent()
+
+			case termbox.KeyBackspace:
+				delete_rune(keyEvent)
+			case termbox.KeyBackspace2:
+				delete_rune(keyEvent)
+			case termbox.KeyDelete:
+				delete_rune(keyEvent)
+
+			case termbox.KeyEnter:
+				insert_line()
+
+			}
+		}
+	}
+
+	if currentRow != prevRow {
+		mark_viewport_dirty()
+	}
+}
+
+func insert_line() {
+
+	// Create a new line, and wrap the remainder of the current line onto the next one
+	currentLine := textBuffer[currentRow]
+	if currentCol > len(currentLine) {
+		currentCol = len(currentLine)
+	}
+
+	indentLen := 0
+	for indentLen < currentCol {
+		ch := currentLine[indentLen]
+		if ch != ' ' && ch != '\t' {
+			break
+		}
+		indentLen++
+	}
+
+	extraIndent := []rune{}
+	if currentCol == len(currentLine) && len(currentLine) > 0 {
+		switch currentLine[len(currentLine)-1] {
+		case '(', '{', '[', ':':
+			extraIndent = indent_unit()
+		}
+	}
+
+	newIndentLen := indentLen + len(extraIndent)
+	newLine := make([]rune, newIndentLen+len(currentLine)-currentCol)
+	copy(newLine[:indentLen], currentLine[:indentLen])
+	copy(newLine[indentLen:newIndentLen], extraIndent)
+	copy(newLine[newIndentLen:], currentLine[currentCol:])
+
+	textBuffer[currentRow] = currentLine[:currentCol]
+	textBuffer = append(textBuffer[:currentRow+1], append([][]rune{newLine}, textBuffer[currentRow+1:]...)...)
+	shift_tracked_positions(position{row: currentRow, col: currentCol}, 1, newIndentLen-currentCol)
+
+	currentRow++
+	currentCol = newIndentLen
+
+	mark_viewport_dirty()
+	mark_line_dirty(currentRow)
+}
+
+func insert_rune(event termbox.Event) {
+	rowBuffer := make([]rune, len(textBuffer[currentRow])+1)
+
+	// Populate line buffer with currentRow contents
+	// from the start of row, to the cursor
+	copy(rowBuffer[:currentCol], textBuffer[currentRow][:currentCol])
+
+	currentRune := &rowBuffer[currentCol]
+
+	switch event.Key {
+	case termbox.KeySpace:
+		*currentRune = rune(' ')
+	case termbox.KeyTab:
+		*currentRune = rune('\t')
+	default:
+		*currentRune = rune(event.Ch)
+	}
+
+	// Finish populating line buffer with currentRow contents
+	// from the cursor to the end of the line
+	copy(rowBuffer[currentCol+1:], textBuffer[currentRow][currentCol:])
+
+	textBuffer[currentRow] = rowBuffer
+	currentCol++
+
+	mark_line_dirty(currentRow)
+}
+
+func delete_rune(event termbox.Event) {
+	lineShifted := false
+
+	switch event.Key {
+
+	// delete the character to the left
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+
+		// If not deleting a newline character
+		if currentCol > 0 {
+			// Inside the indentation, this removes a whole indent level
+			width := backspace_width()
+			currentCol -= width
+
+			deleteLine := make([]rune, len(textBuffer[currentRow])-width)
+			copy(deleteLine[:currentCol], textBuffer[currentRow][:currentCol])
+			copy(deleteLine[currentCol:], textBuffer[currentRow][currentCol+width:])
+			textBuffer[currentRow] = deleteLine
+
+		} else if currentRow > 0 {
+
+			// If deleting a newline character, wrap the text onto the previous line
+			wrapText := make([]rune, len(textBuffer[currentRow]))
+			copy(wrapText, textBuffer[currentRow][currentCol:])
+
+			prevLineLen := len(textBuffer[currentRow-1])
+			appendLine := make([]rune, len(textBuffer[currentRow-1])+len(wrapText))
+			copy(appendLine[:len(textBuffer[currentRow-1])], textBuffer[currentRow-1])
+			copy(appendLine[len(textBuffer[currentRow-1]):], wrapText)
+
+			textBuffer[currentRow-1] = appendLine
+			textBuffer = append(textBuffer[:currentRow], textBuffer[currentRow+1:]...)
+			shift_tracked_positions(position{row: currentRow, col: 0}, -1, prevLineLen)
+
+			currentRow--
+			currentCol = prevLineLen
+			lineShifted = true
+
+		}
+
+	// Delete the character to the right
+	case termbox.KeyDelete:
+
+		// If not deleting a newline character
+		if currentCol < len(textBuffer[currentRow]) {
+
+			deleteLine := make([]rune, len(textBuffer[currentRow])-1)
+			copy(deleteLine[:currentCol], textBuffer[currentRow][:currentCol+1])
+			copy(deleteLine[currentCol:], textBuffer[currentRow][currentCol+1:])
+			textBuffer[currentRow] = deleteLine
+
+		} else if currentRow < len(textBuffer)-1 {
+
+			// If deleting a newline character, wrap the next line on top of the currentRow
+			wrapText := textBuffer[currentRow+1]
+
+			currentLineLen := len(textBuffer[currentRow])
+			appendLine := make([]rune, len(textBuffer[currentRow])+len(wrapText))
+			copy(appendLine[:len(textBuffer[currentRow])], textBuffer[currentRow])
+			copy(appendLine[len(textBuffer[currentRow]):], wrapText)
+
+			textBuffer[currentRow] = appendLine
+			textBuffer = append(textBuffer[:currentRow+1], textBuffer[currentRow+2:]...)
+			shift_tracked_positions(position{row: currentRow + 1, col: 0}, -1, currentLineLen)
+
+			currentCol = currentLineLen
+			lineShifted = true
+
+		}
+	}
+
+	if lineShifted {
+		mark_viewport_dirty()
+	}
+	mark_line_dirty(currentRow)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Each open file has its own buffer. The editor always works on the globals,
+// which belong to the current buffer; switching stashes them in a bufferState
+// and brings back the other buffer's.
+
+type bufferState struct {
+	textBuffer    [][]rune
+	filename      string
+	fileExtension string
+	modified      bool
+	readOnly      bool
+	bufferVersion int
+	indentation   indentStyle
+	fileFormat    fileFormatState
+	diskFile      diskState
+	saveFailed    bool
+
+	autoSaveFailedVersion int
+
+	currentRow, currentCol int
+	offsetRow, offsetCol   int
+
+	undoStack   stack
+	marks       map[rune]position
+	jumpList    []position
+	jumpIndex   int
+	changeList  []position
+	changeIndex int
+
+	hexActive bool
+	hexData   []byte
+	hexCursor int
+	hexOffset int
+	hexPane   int
+	hexNibble int
+
+	largeFile     bool
+	largeHandle   *os.File
+	largeIndex    *largeIndexer
+	largeIndexing bool
+	largeOffsets  []int64
+	largeLoaded   []int
+
+	swapChecked   bool
+	swapPath      string
+	swapRecovery  *swapFile
+	swapVersion   int
+	swapModified  bool
+	swapLastWrite time.Time
+
+	syntaxRows    []syntaxRow
+	syntaxChecked int
+	syntaxVersion int
+	syntaxLang    *syntaxLanguage
+	syntaxChoice  string
+}
+
+var (
+	buffers       []bufferState
+	currentBuffer int
+
+	// The current buffer can't be edited, e.g. it was opened with -R
+	readOnly bool
+
+	errReadOnly = errors.New("buffer is read-only, :noreadonly to edit it anyway")
+)
+
+// empty_buffer is a new buffer with nothing in it, and no file yet
+func empty_buffer() bufferState {
+	return bufferState{
+		textBuffer:  [][]rune{{}},
+		indentation: indentStyle{expandTab: EXPAND_TAB, width: INDENT_WIDTH},
+		fileFormat:  new_file_format(),
+		marks:       map[rune]position{},
+		swapVersion: -1,
+
+		autoSaveFailedVersion: -1,
+	}
+}
+
+// stash_buffer takes the current buffer out of the globals
+func stash_buffer() bufferState {
+	return bufferState{
+		textBuffer:    textBuffer,
+		filename:      filename,
+		fileExtension: fileExtension,
+		modified:      modified,
+		readOnly:      readOnly,
+		bufferVersion: bufferVersion,
+		indentation:   indentation,
+		fileFormat:    fileFormat,
+		diskFile:      diskFile,
+		saveFailed:    saveFailed,
+
+		autoSaveFailedVersion: autoSaveFailedVersion,
+
+		currentRow: currentRow,
+		currentCol: currentCol,
+		offsetRow:  offsetRow,
+		offsetCol:  offsetCol,
+
+		undoStack:   undoStack,
+		marks:       marks,
+		jumpList:    jumpList,
+		jumpIndex:   jumpIndex,
+		changeList:  changeList,
+		changeIndex: changeIndex,
+
+		hexActive: hexActive,
+		hexData:   hexData,
+		hexCursor: hexCursor,
+		hexOffset: hexOffset,
+		hexPane:   hexPane,
+		hexNibble: hexNibble,
+
+		largeFile:     largeFile,
+		largeHandle:   largeHandle,
+		largeIndex:    largeIndex,
+		largeIndexing: largeIndexing,
+		largeOffsets:  largeOffsets,
+		largeLoaded:   largeLoaded,
+
+		swapChecked:   swapChecked,
+		swapPath:      swapPath,
+		swapRecovery:  swapRecovery,
+		swapVersion:   swapVersion,
+		swapModified:  swapModified,
+		swapLastWrite: swapLastWrite,
+
+		syntaxRows:    syntaxRows,
+		syntaxChecked: syntaxChecked,
+		syntaxVersion: syntaxVersion,
+		syntaxLang:    syntaxLang,
+		syntaxChoice:  syntaxChoice,
+	}
+}
+
+// restore_buffer puts a stashed buffer back in the globals
+func restore_buffer(b bufferState) {
+	textBuffer = b.textBuffer
+	filename = b.filename
+	fileExtension = b.fileExtension
+	modified = b.modified
+	readOnly = b.readOnly
+	bufferVersion = b.bufferVersion
+	indentation = b.indentation
+	fileFormat = b.fileFormat
+	diskFile = b.diskFile
+	saveFailed = b.saveFailed
+	autoSaveFailedVersion = b.autoSaveFailedVersion
+
+	currentRow, currentCol = b.currentRow, b.currentCol
+	offsetRow, offsetCol = b.offsetRow, b.offsetCol
+
+	undoStack = b.undoStack
+	marks = b.marks
+	jumpList, jumpIndex = b.jumpList, b.jumpIndex
+	changeList, changeIndex = b.changeList, b.changeIndex
+
+	hexActive = b.hexActive
+	hexData = b.hexData
+	hexCursor, hexOffset = b.hexCursor, b.hexOffset
+	hexPane, hexNibble = b.hexPane, b.hexNibble
+
+	largeFile = b.largeFile
+	largeHandle = b.largeHandle
+	largeIndex = b.largeIndex
+	largeIndexing = b.largeIndexing
+	largeOffsets = b.largeOffsets
+	largeLoaded = b.largeLoaded
+
+	swapChecked = b.swapChecked
+	swapPath = b.swapPath
+	swapRecovery = b.swapRecovery
+	swapVersion = b.swapVersion
+	swapModified = b.swapModified
+	swapLastWrite = b.swapLastWrite
+
+	syntaxRows = b.syntaxRows
+	syntaxChecked = b.syntaxChecked
+	syntaxVersion = b.syntaxVersion
+	syntaxLang = b.syntaxLang
+	syntaxChoice = b.syntaxChoice
+
+	// Anything half typed belonged to the other buffer
+	reset_jump_state()
+	reset_operator_state()
+	markPending = 0
+	countPending = false
+
+	dirtyRows = dirtyRows[:0]
+	sync_dirty_rows()
+	mark_viewport_dirty()
+}
+
+// add_buffer makes a new empty buffer the current one, ready to load a file into
+func add_buffer() {
+	if len(buffers) > 0 {
+		buffers[currentBuffer] = stash_buffer()
+	}
+	buffers = append(buffers, empty_buffer())
+	currentBuffer = len(buffers) - 1
+	restore_buffer(buffers[currentBuffer])
+}
+
+func switch_buffer(index int) {
+	if index == currentBuffer {
+		return
+	}
+	if AUTO_SAVE_ON_SWITCH {
+		auto_save()
+	}
+	use_buffer(index)
+	show_buffer()
+}
+
+// use_buffer makes another buffer current without showing it,
+// for work on it that mustn't ask anything
+func use_buffer(index int) {
+	buffers[currentBuffer] = stash_buffer()
+	currentBuffer = index
+	restore_buffer(buffers[index])
+}
+
+// show_buffer starts journaling a buffer the first time it's shown,
+// so only one recovery prompt comes up at a time
+func show_buffer() {
+	open_swap(filename + fileExtension)
+	check_disk_file()
+}
+
+// swap_paths is every buffer's swap file
+func swap_paths() []string {
+	var paths []string
+	for i, b := range buffers {
+		if i == currentBuffer {
+			b.swapPath = swapPath
+		}
+		if b.swapPath != "" {
+			paths = append(paths, b.swapPath)
+		}
+	}
+	return paths
+}
+
+// buffer_name is how a buffer is listed
+func buffer_name(b bufferState) string {
+	name := strconv.Itoa(len(b.textBuffer)) + " lines"
+	if b.hexActive {
+		name = strconv.Itoa(len(b.hexData)) + " bytes"
+	}
+	name = display_name(b.filename, b.fileExtension) + " - " + name
+	if b.modified {
+		name += " [+]"
+	}
+	return name
+}
+
+func list_buffers() {
+	buffers[currentBuffer] = stash_buffer()
+	lines := make([]pagerLine, len(buffers))
+	for i, b := range buffers {
+		marker := "  "
+		if i == currentBuffer {
+			marker = "% "
+		}
+		lines[i] = pagerLine{text: strconv.Itoa(i+1) + " " + marker + buffer_name(b), fg: termbox.ColorDefault}
+	}
+	open_pager("buffers", lines)
+}
+
+// ---------- Buffer Commands ----------
+
+func cmd_bnext(args []string) error {
+	switch_buffer((currentBuffer + 1) % len(buffers))
+	return nil
+}
+
+func cmd_bprev(args []string) error {
+	switch_buffer((currentBuffer + len(buffers) - 1) % len(buffers))
+	return nil
+}
+
+// cmd_buffer switches to a buffer by number, or by part of its name
+func cmd_buffer(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: buffer <number|name>")
+	}
+	if n, err := strconv.Atoi(args[0]); err == nil {
+		if n < 1 || n > len(buffers) {
+			return errors.New("buffer: no buffer " + args[0])
+		}
+		switch_buffer(n - 1)
+		return nil
+	}
+
+	buffers[currentBuffer] = stash_buffer()
+	match := -1
+	for i, b := range buffers {
+		if strings.Contains(b.filename+b.fileExtension, args[0]) {
+			if match >= 0 {
+				return errors.New("buffer: more than one buffer matches " + args[0])
+			}
+			match = i
+		}
+	}
+	if match < 0 {
+		return errors.New("buffer: no buffer matches " + args[0])
+	}
+	switch_buffer(match)
+	return nil
+}
+
+func cmd_buffers(args []string) error {
+	list_buffers()
+	return nil
+}
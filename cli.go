@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Set when building a release, with -ldflags "-X main.version=..."
+var version = "dev"
+
+const USAGE = `usage: goatpad [options] [+line] [file[:line[:col]]]...
+
+Opens each file in its own buffer, or an empty buffer if there are none.
+
+  +line          open the next file at this line
+  file:line:col  open file at this line and column
+  -              read the text from standard input
+
+options:
+  -R, --readonly  open the files read-only
+  --pipe          edit standard input, and write it to standard output
+                  on quit, e.g. goatpad --pipe < in > out
+  --config path   run the commands in path at startup, instead of the default
+                  config file
+  --version       print the version and exit
+  -h, --help      print this help and exit
+  --              treat everything after this as a file name
+`
+
+// cliFile is a file to open, and where to put the cursor in it
+type cliFile struct {
+	path  string
+	stdin bool
+	pos   position
+}
+
+type cliOptions struct {
+	files    []cliFile
+	readOnly bool
+	pipe     bool
+	config   string
+	help     bool
+	version  bool
+}
+
+func parse_args(args []string) (cliOptions, error) {
+	var opts cliOptions
+	var line int
+	filesOnly := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case filesOnly || arg == "-" || !strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "+"):
+			file := cliFile{path: arg, stdin: arg == "-" && !filesOnly}
+			if !file.stdin {
+				file.path, file.pos = split_file_position(arg)
+			}
+			if line > 0 {
+				file.pos = position{row: line - 1}
+				line = 0
+			}
+			opts.files = append(opts.files, file)
+
+		case strings.HasPrefix(arg, "+"):
+			n, err := strconv.Atoi(arg[1:])
+			if err != nil || n < 1 {
+				return opts, errors.New("not a line number: " + arg)
+			}
+			line = n
+
+		case arg == "--":
+			filesOnly = true
+		case arg == "-R", arg == "--readonly":
+			opts.readOnly = true
+		case arg == "--pipe":
+			opts.pipe = true
+		case arg == "-h", arg == "--help":
+			opts.help = true
+		case arg == "--version":
+			opts.version = true
+
+		case arg == "--config":
+			if i+1 >= len(args) {
+				return opts, errors.New("--config needs a path")
+			}
+			i++
+			opts.config = args[i]
+		case strings.HasPrefix(arg, "--config="):
+			opts.config = strings.TrimPrefix(arg, "--config=")
+
+		default:
+			return opts, errors.New("unknown option: " + arg)
+		}
+	}
+
+	// A +line with no file after it is for the empty buffer
+	if line > 0 {
+		opts.files = append(opts.files, cliFile{path: "", pos: position{row: line - 1}})
+	}
+
+	// A pipe only has standard input to edit
+	if opts.pipe {
+		file := cliFile{stdin: true}
+		for _, f := range opts.files {
+			if !f.stdin && f.path != "" {
+				return opts, errors.New("--pipe edits standard input, and can't open " + f.path)
+			}
+			file.pos = f.pos
+		}
+		opts.files = []cliFile{file}
+	}
+	return opts, nil
+}
+
+// split_file_position takes a ":line" or ":line:col" off the end of arg,
+// unless arg is the name of a file that exists as it is
+func split_file_position(arg string) (string, position) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, position{}
+	}
+
+	path, pos := arg, position{}
+	var numbers []int
+	for len(numbers) < 2 {
+		i := strings.LastIndex(path, ":")
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(path[i+1:])
+		if err != nil || n < 1 {
+			break
+		}
+		numbers = append([]int{n}, numbers...)
+		path = path[:i]
+	}
+	if len(numbers) == 0 || path == "" {
+		return arg, position{}
+	}
+	pos.row = numbers[0] - 1
+	if len(numbers) == 2 {
+		pos.col = numbers[1] - 1
+	}
+	return path, pos
+}
+
+// cli_error reports a mistake on the command line, before the editor starts
+func cli_error(err error) {
+	fmt.Fprintln(os.Stderr, "goatpad: "+err.Error())
+	fmt.Fprintln(os.Stderr, "Try 'goatpad --help' for more information.")
+	os.Exit(2)
+}
+
+// ---------- Config File ----------
+
+// config_path is where the config file is read from when --config isn't given
+func config_path() string {
+	if CONFIG_FILE != "" {
+		return CONFIG_FILE
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "goatpad", "config")
+}
+
+// read_config reads the commands in a config file, one per line.
+// Blank lines and lines starting with # are skipped.
+func read_config(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	return lines, scanner.Err()
+}
+
+// run_config runs the config file's commands on the current buffer
+func run_config(path string, lines []string) {
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := run_command(line); err != nil {
+			show_error(path + ":" + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+	}
+}
+
+// ---------- Opening Files ----------
+
+// open_files loads every file from the command line into its own buffer
+func open_files(opts cliOptions, stdin []byte, config string, configLines []string) {
+	files := opts.files
+	if len(files) == 0 {
+		files = []cliFile{{path: ""}}
+	}
+
+	for _, file := range files {
+		if !file.stdin && file.path != "" {
+			if other := find_buffer(file.path); other >= 0 {
+				show_warning(file.path + " is already open in buffer " + strconv.Itoa(other+1))
+				continue
+			}
+		}
+		add_buffer()
+		switch {
+		case file.stdin && opts.pipe:
+			pipeMode = true
+			filename = PIPE_BUFFER_NAME
+			load_data(stdin, "")
+			pipeOutput = stdin
+		case file.stdin:
+			load_data(stdin, "")
+			// Piped text isn't saved anywhere yet
+			modified = true
+		case file.path != "":
+			set_file_name(file.path)
+			read_file(file.path)
+		}
+		// Files that can't be saved are read-only until asked otherwise
+		readOnly = opts.readOnly || !file.stdin && file.path != "" && !file_writable(file.path)
+		run_config(config, configLines)
+		go_to_position(file.pos)
+	}
+
+	buffers[currentBuffer] = stash_buffer()
+	currentBuffer = 0
+	restore_buffer(buffers[0])
+}
+
+// read_stdin reads piped text, if - or --pipe was given
+func read_stdin(opts cliOptions) ([]byte, error) {
+	for _, file := range opts.files {
+		if file.stdin {
+			return io.ReadAll(os.Stdin)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// The command line is opened with COMMAND_KEY, and runs
+// a named command from the table below, e.g. ":shiftwidth 2"
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"expandtab":   cmd_expandtab,
+	"noexpandtab": cmd_noexpandtab,
+	"shiftwidth":  cmd_shiftwidth,
+
+	"messages": cmd_messages,
+
+	"bnext":   cmd_bnext,
+	"bn":      cmd_bnext,
+	"bprev":   cmd_bprev,
+	"bp":      cmd_bprev,
+	"buffer":  cmd_buffer,
+	"b":       cmd_buffer,
+	"buffers": cmd_buffers,
+	"ls":      cmd_buffers,
+
+	"lineending":     cmd_lineending,
+	"bom":            cmd_bom,
+	"nobom":          cmd_nobom,
+	"finalnewline":   cmd_finalnewline,
+	"nofinalnewline": cmd_nofinalnewline,
+	"encoding":       cmd_encoding,
+	"reopen":         cmd_reopen,
+
+	"quit":       cmd_quit,
+	"q":          cmd_quit,
+	"quit!":      cmd_force_quit,
+	"q!":         cmd_force_quit,
+	"bclose":     cmd_bclose,
+	"bd":         cmd_bclose,
+	"bclose!":    cmd_force_bclose,
+	"bd!":        cmd_force_bclose,
+	"saveas":     cmd_saveas,
+	"readonly":   cmd_readonly,
+	"noreadonly": cmd_noreadonly,
+	"suspend":    cmd_suspend,
+	"syntax":     cmd_syntax,
+
+	"hex":     cmd_hex,
+	"hexfind": cmd_hexfind,
+}
+
+// Commands that change the buffer, refused when it can't be edited
+var editCommands = map[string]bool{
+	"lineending":     true,
+	"bom":            true,
+	"nobom":          true,
+	"finalnewline":   true,
+	"nofinalnewline": true,
+	"encoding":       true,
+}
+
+var (
+	commandActive bool
+	commandInput  []rune
+)
+
+func open_command_line() {
+	commandActive = true
+	commandInput = commandInput[:0]
+}
+
+func close_command_line() {
+	commandActive = false
+	commandInput = commandInput[:0]
+}
+
+func handle_command_key(event termbox.Event) {
+	switch {
+	case event.Ch != 0:
+		commandInput = append(commandInput, event.Ch)
+
+	case event.Key == termbox.KeySpace:
+		commandInput = append(commandInput, ' ')
+
+	case event.Key == termbox.KeyBackspace || event.Key == termbox.KeyBackspace2:
+		if len(commandInput) == 0 {
+			close_command_line()
+		} else {
+			commandInput = commandInput[:len(commandInput)-1]
+		}
+
+	case event.Key == termbox.KeyEnter:
+		line := string(commandInput)
+		close_command_line()
+		if err := run_command(line); err != nil {
+			show_error(err.Error())
+		}
+
+	case event.Key == termbox.KeyEsc:
+		close_command_line()
+	}
+}
+
+func run_command(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return errors.New("unknown command: " + fields[0])
+	}
+	if editCommands[fields[0]] {
+		if err := buffer_editable(); err != nil {
+			return errors.New(fields[0] + ": " + err.Error())
+		}
+	}
+	return cmd(fields[1:])
+}
+
+func cmd_messages(args []string) error {
+	open_message_history()
+	return nil
+}
+
+// ---------- Indentation Commands ----------
+
+func cmd_expandtab(args []string) error {
+	indentation.expandTab = true
+	return nil
+}
+
+func cmd_noexpandtab(args []string) error {
+	indentation.expandTab = false
+	return nil
+}
+
+func cmd_shiftwidth(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: shiftwidth <columns>")
+	}
+	width, err := strconv.Atoi(args[0])
+	if err != nil || width < 1 {
+		return errors.New("shiftwidth: not a width: " + args[0])
+	}
+	indentation.width = width
+	return nil
+}
+
+// ---------- File Format Commands ----------
+
+// These only change how the buffer is written on the next save
+
+func cmd_lineending(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lineending <lf|crlf|cr>")
+	}
+	lineEnding, ok := parse_line_ending(args[0])
+	if !ok {
+		return errors.New("lineending: unknown line ending: " + args[0])
+	}
+	strip_trailing_cr()
+	format := fileFormat
+	format.lineEnding = lineEnding
+	set_file_format(format)
+	return nil
+}
+
+func cmd_bom(args []string) error {
+	format := fileFormat
+	format.bom = true
+	set_file_format(format)
+	return nil
+}
+
+func cmd_nobom(args []string) error {
+	format := fileFormat
+	format.bom = false
+	set_file_format(format)
+	return nil
+}
+
+func cmd_finalnewline(args []string) error {
+	format := fileFormat
+	format.finalNewline = true
+	set_file_format(format)
+	return nil
+}
+
+func cmd_nofinalnewline(args []string) error {
+	format := fileFormat
+	format.finalNewline = false
+	set_file_format(format)
+	return nil
+}
+
+// ---------- Encoding Commands ----------
+
+// encoding changes what the buffer is saved as
+func cmd_encoding(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: encoding <utf-8|utf-16le|utf-16be|latin1>")
+	}
+	encoding, ok := parse_encoding(args[0])
+	if !ok {
+		return errors.New("encoding: unknown encoding: " + args[0])
+	}
+	format := fileFormat
+	format.encoding = encoding
+	// Latin-1 has no byte order mark
+	if encoding == ENCODING_LATIN1 {
+		format.bom = false
+	}
+	set_file_format(format)
+	return nil
+}
+
+// reopen reads the file again in another encoding, for when the guess was wrong
+func cmd_reopen(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: reopen <utf-8|utf-16le|utf-16be|latin1>")
+	}
+	encoding, ok := parse_encoding(args[0])
+	if !ok {
+		return errors.New("reopen: unknown encoding: " + args[0])
+	}
+	if modified {
+		return errors.New("reopen: the buffer has unsaved changes")
+	}
+	if filename+fileExtension == "" || pipeMode {
+		return errors.New("reopen: the buffer has no file")
+	}
+	reload_file_as(encoding)
+	return nil
+}
+
+// ---------- File Commands ----------
+
+func cmd_quit(args []string) error {
+	quit_editor()
+	return nil
+}
+
+func cmd_force_quit(args []string) error {
+	discard_and_quit()
+	return nil
+}
+
+func cmd_suspend(args []string) error {
+	suspend()
+	return nil
+}
+
+func cmd_bclose(args []string) error {
+	close_current_buffer()
+	return nil
+}
+
+func cmd_force_bclose(args []string) error {
+	remove_current_buffer()
+	return nil
+}
+
+// cmd_saveas saves to a new path, which the buffer then belongs to
+func cmd_saveas(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: saveas <path>")
+	}
+	if err := save_as(strings.Join(args, " ")); err != nil {
+		return errors.New("saveas: " + err.Error())
+	}
+	return nil
+}
+
+func cmd_readonly(args []string) error {
+	readOnly = true
+	if mode != 0 {
+		switch_mode("View")
+	}
+	return nil
+}
+
+// cmd_noreadonly allows editing a read-only buffer. Saving may still fail
+// if the file can't be written.
+func cmd_noreadonly(args []string) error {
+	readOnly = false
+	if !file_writable(filename + fileExtension) {
+		show_warning(display_name(filename, fileExtension) + " isn't writable, saving it may fail")
+	}
+	return nil
+}
+
+// ---------- Hex Commands ----------
+
+func cmd_hex(args []string) error {
+	return toggle_hex()
+}
+
+// hexfind searches for hex bytes like "de ad be ef" or quoted text,
+// or the last pattern again if there's none
+func cmd_hexfind(args []string) error {
+	if !hexActive {
+		return errors.New("hexfind: only works in the hex view, see :hex")
+	}
+	pattern := hexLastPattern
+	if len(args) > 0 {
+		var err error
+		pattern, err = parse_byte_pattern(strings.Join(args, " "))
+		if err != nil {
+			return err
+		}
+		hexLastPattern = pattern
+	}
+	if len(pattern) == 0 {
+		return errors.New("usage: hexfind <hex bytes|\"text\">")
+	}
+	if !hex_find(pattern) {
+		return errors.New("hexfind: pattern not found")
+	}
+	return nil
+}
@@ -1,56 +1,179 @@
-package main
-
-import "github.com/nsf/termbox-go"
-
-// Case sensitive
-
-// Preferences
-const (
-	TAB_WIDTH    int               = 4
-	SCROLLMARGIN int               = 5
-	RULER_COL    int               = 80
-	RULER_BG     termbox.Attribute = termbox.ColorGreen
-)
-
-// Controls
-const (
-	TOGGLE_MODE_KEY termbox.Key = termbox.KeyEsc
-	QUIT_NOSAVE     rune        = 'z'
-	QUIT_SAVE       rune        = 'x'
-	SAVE_NOQUIT     termbox.Key = termbox.KeyCtrlS
-)
-
-// Navigation
-const (
-	CURSOR_LEFT   rune        = 'j'
-	CURSOR_DOWN   rune        = 'k'
-	CURSOR_UP     rune        = 'l'
-	CURSOR_RIGHT  rune        = ';'
-	JUMP_UP       rune        = 'g'
-	JUMP_DOWN     rune        = 'h'
-	PAGE_UP       termbox.Key = termbox.KeyPgup
-	PAGE_DOWN     termbox.Key = termbox.KeyPgdn
-	START_OF_LINE termbox.Key = termbox.KeyHome
-	END_OF_LINE   termbox.Key = termbox.KeyEnd
-)
-
-// Copy-Paste
-const (
-	COPY_SYMBOL_KEY  rune = '1'
-	CUT_SYMBOL_KEY   rune = '2'
-	PASTE_SYMBOL_KEY rune = '3'
-	DEL_SYMBOL_KEY   rune = '4'
-
-	COPY_LINE_KEY  rune = 'q'
-	CUT_LINE_KEY   rune = 'w'
-	PASTE_LINE_KEY rune = 'e'
-	DEL_LINE_KEY   rune = 'r'
-
-	COPY_BLOCK_KEY  rune = 'a'
-	CUT_BLOCK_KEY   rune = 's'
-	PASTE_BLOCK_KEY rune = 'd'
-	DEL_BLOCK_KEY   rune = 'f'
-
-	MANUAL_SAVE_STATE rune = 'c'
-	ROLLBACK_STATE    rune = 'v'
-)
+package main
+
+import (
+	"time"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Case sensitive
+
+// Preferences
+const (
+	TAB_WIDTH    int               = 4
+	SCROLLMARGIN int               = 5
+	RULER_COL    int               = 80
+	RULER_BG     termbox.Attribute = termbox.ColorGreen
+
+	// Open files that look binary in the hex view
+	HEX_DETECT bool = true
+
+	// Files this big or bigger are read lazily and opened read-only,
+	// without swap files, hashing or detection. 0 turns this off.
+	LARGE_FILE_SIZE int64 = 64 * 1024 * 1024
+
+	// Colour for control characters and invalid bytes, drawn as e.g. ^M or <80>
+	CONTROL_CHAR_FG termbox.Attribute = termbox.ColorCyan
+
+	// Colour code by file type, with grammars in syntax/ and
+	// goatpad/syntax in the user config directory
+	SYNTAX_HIGHLIGHTING bool = true
+
+	// Indentation, used unless DETECT_INDENT finds the file does otherwise
+	EXPAND_TAB    bool = true
+	INDENT_WIDTH  int  = 4
+	DETECT_INDENT bool = true
+
+	// Line endings for new files; opened files keep their own
+	NEW_FILE_LINE_ENDING   string = "\n"
+	NEW_FILE_FINAL_NEWLINE bool   = true
+
+	// How long messages stay under the status bar
+	MESSAGE_TIMEOUT       time.Duration = 4 * time.Second
+	ERROR_MESSAGE_TIMEOUT time.Duration = 10 * time.Second
+
+	// Commands run at startup, "" for goatpad/config in the user config directory
+	CONFIG_FILE string = ""
+
+	// Auto-save after this long without typing, 0 to turn it off,
+	// and when switching buffers or the terminal loses focus
+	AUTO_SAVE_IDLE          time.Duration = 0
+	AUTO_SAVE_ON_SWITCH     bool          = false
+	AUTO_SAVE_ON_FOCUS_LOSS bool          = false
+
+	// Unsaved changes are journaled here, "" for the user cache directory
+	SWAP_DIR      string        = ""
+	SWAP_INTERVAL time.Duration = 4 * time.Second
+
+	// How often to check whether another program changed the file,
+	// and whether to also check when the terminal regains focus
+	DISK_CHECK_INTERVAL time.Duration = 2 * time.Second
+	FOCUS_EVENTS        bool          = true
+
+	// How often timed work like journaling and disk checks runs
+	WAKE_INTERVAL time.Duration = time.Second
+)
+
+// Syntax Highlighting
+// Colours for each class of token in the syntax files
+const (
+	SYNTAX_KEYWORD_FG  termbox.Attribute = termbox.ColorYellow
+	SYNTAX_TYPE_FG     termbox.Attribute = termbox.ColorGreen
+	SYNTAX_CONSTANT_FG termbox.Attribute = termbox.ColorMagenta
+	SYNTAX_NUMBER_FG   termbox.Attribute = termbox.ColorMagenta
+	SYNTAX_STRING_FG   termbox.Attribute = termbox.ColorRed
+	SYNTAX_COMMENT_FG  termbox.Attribute = termbox.ColorBlue
+	SYNTAX_SPECIAL_FG  termbox.Attribute = termbox.ColorCyan
+)
+
+// Controls
+const (
+	TOGGLE_MODE_KEY termbox.Key = termbox.KeyEsc
+	QUIT_NOSAVE     rune        = 'z' // asks first if anything is unsaved
+	QUIT_SAVE       rune        = 'x'
+	FORCE_QUIT      rune        = 'Z' // quits without asking
+	SAVE_NOQUIT     termbox.Key = termbox.KeyCtrlS
+	SUSPEND_KEY     termbox.Key = termbox.KeyCtrlZ // continue with fg
+	COMMAND_KEY     rune        = ':'
+)
+
+// Navigation
+const (
+	CURSOR_LEFT   rune        = 'j'
+	CURSOR_DOWN   rune        = 'k'
+	CURSOR_UP     rune        = 'l'
+	CURSOR_RIGHT  rune        = ';'
+	JUMP_UP       rune        = 'g'
+	JUMP_DOWN     rune        = 'h'
+	PAGE_UP       termbox.Key = termbox.KeyPgup
+	PAGE_DOWN     termbox.Key = termbox.KeyPgdn
+	START_OF_LINE termbox.Key = termbox.KeyHome
+	END_OF_LINE   termbox.Key = termbox.KeyEnd
+
+	// Words are alphanumeric runs (like symbols), WORDs are any non-blank runs
+	NEXT_WORD_START     rune = 't'
+	PREV_WORD_START     rune = 'b'
+	NEXT_WORD_END       rune = 'm'
+	PREV_WORD_END       rune = 'n'
+	NEXT_BIG_WORD_START rune = 'T'
+	PREV_BIG_WORD_START rune = 'B'
+	NEXT_BIG_WORD_END   rune = 'M'
+	PREV_BIG_WORD_END   rune = 'N'
+
+	PREV_SENTENCE  rune = '('
+	NEXT_SENTENCE  rune = ')'
+	PREV_PARAGRAPH rune = '{'
+	NEXT_PARAGRAPH rune = '}'
+
+	LINE_START_MOTION  rune = '^'
+	LINE_END_MOTION    rune = '$'
+	BLOCK_START_MOTION rune = '['
+	BLOCK_END_MOTION   rune = ']'
+
+	// Prefix for a count, e.g. "#3j" moves down 3 lines
+	COUNT_KEY rune = '#'
+)
+
+// Marks and History
+// Marks are set and used with a name, e.g. "@a" then "`a"
+const (
+	SET_MARK_KEY       rune        = '@'
+	GOTO_MARK_LINE_KEY rune        = '\''
+	GOTO_MARK_KEY      rune        = '`'
+	JUMP_BACK_KEY      termbox.Key = termbox.KeyCtrlO
+	JUMP_FORWARD_KEY   termbox.Key = termbox.KeyTab // also Ctrl+I
+	CHANGE_BACK_KEY    rune        = '-'
+	CHANGE_FORWARD_KEY rune        = '='
+)
+
+// Copy-Paste
+const (
+	COPY_SYMBOL_KEY  rune = '1'
+	CUT_SYMBOL_KEY   rune = '2'
+	PASTE_SYMBOL_KEY rune = '3'
+	DEL_SYMBOL_KEY   rune = '4'
+
+	COPY_LINE_KEY  rune = 'q'
+	CUT_LINE_KEY   rune = 'w'
+	PASTE_LINE_KEY rune = 'e'
+	DEL_LINE_KEY   rune = 'r'
+
+	COPY_BLOCK_KEY  rune = 'a'
+	CUT_BLOCK_KEY   rune = 's'
+	PASTE_BLOCK_KEY rune = 'd'
+	DEL_BLOCK_KEY   rune = 'f'
+
+	INDENT_BLOCK_KEY  rune = 'I'
+	OUTDENT_BLOCK_KEY rune = 'O'
+
+	MANUAL_SAVE_STATE rune = 'c'
+	ROLLBACK_STATE    rune = 'v'
+)
+
+// Operators
+// <operator><motion>, e.g. "p$" deletes to the end of the line
+// <operator><scope><object>, e.g. "pi(" deletes a call's arguments
+const (
+	COPY_OPERATOR_KEY    rune = 'y'
+	CUT_OPERATOR_KEY     rune = 'u'
+	CHANGE_OPERATOR_KEY  rune = 'o'
+	DEL_OPERATOR_KEY     rune = 'p'
+	INDENT_OPERATOR_KEY  rune = '>'
+	OUTDENT_OPERATOR_KEY rune = '<'
+	CASE_OPERATOR_KEY    rune = '~'
+
+	INSIDE_OBJECT    rune = 'i'
+	AROUND_OBJECT    rune = 'a'
+	PARAGRAPH_OBJECT rune = 'p'
+	ARGUMENT_OBJECT  rune = ','
+)
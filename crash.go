@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// If the editor panics, the terminal is put back to normal and every buffer
+// with unsaved changes is written out before exiting. Buffers with a swap file
+// are written to it, so the recovery prompt offers them on the next start.
+// Others are written to a .recovered file beside the swap files.
+
+// goroutinePanic carries a panic from a background goroutine to the UI
+// goroutine, with the stack of where it happened
+type goroutinePanic struct {
+	value any
+	stack []byte
+}
+
+// forward_panic is deferred by background goroutines, so their panics
+// reach recover_crash too
+func forward_panic() {
+	if value := recover(); value != nil {
+		crash := goroutinePanic{value: value, stack: debug.Stack()}
+		post(func() { panic(crash) })
+	}
+}
+
+// recover_crash is deferred by main
+func recover_crash() {
+	value := recover()
+	if value == nil {
+		return
+	}
+	stack := debug.Stack()
+	if crash, ok := value.(goroutinePanic); ok {
+		value, stack = crash.value, crash.stack
+	}
+
+	// Unsaved work comes first, so it's safe even if restoring the terminal hangs
+	paths, failures := write_recovery_files()
+	disable_focus_reporting()
+	termbox.Close()
+
+	fmt.Fprintf(os.Stderr, "goatpad crashed: %v\n\n%s\n", value, stack)
+	for _, path := range paths {
+		fmt.Fprintln(os.Stderr, "unsaved changes written to "+path)
+	}
+	for _, failure := range failures {
+		fmt.Fprintln(os.Stderr, "couldn't write unsaved changes: "+failure)
+	}
+	os.Exit(2)
+}
+
+// write_recovery_files writes out every buffer with unsaved changes,
+// returning where they went and what couldn't be written
+func write_recovery_files() (paths []string, failures []string) {
+	if len(buffers) == 0 {
+		return nil, nil
+	}
+	buffers[currentBuffer] = stash_buffer()
+
+	// Stop journaling first, so a write still queued or in progress
+	// can't replace what's recovered with something older
+	swapMutex.Lock()
+	defer swapMutex.Unlock()
+	swapClosed = true
+
+	for i := range buffers {
+		if !buffers[i].modified {
+			continue
+		}
+		path, err := write_recovery_file(i)
+		if err != nil {
+			failures = append(failures, display_name(buffers[i].filename, buffers[i].fileExtension)+": "+err.Error())
+		} else {
+			paths = append(paths, path)
+		}
+	}
+	remove_clean_swaps()
+	return paths, failures
+}
+
+// write_recovery_file writes out one buffer. Its state may be what caused
+// the panic, so panicking again is caught and reported as an error.
+func write_recovery_file(index int) (path string, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = fmt.Errorf("%v", value)
+		}
+	}()
+	restore_buffer(buffers[index])
+
+	if swapPath != "" && !hexActive {
+		return swapPath, write_swap_file(swapPath, encode_swap())
+	}
+
+	dir, err := swap_dir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	name := filepath.Base(filename + fileExtension)
+	if filename+fileExtension == "" || pipeMode {
+		name = "unnamed"
+	}
+	name = strings.Join([]string{name, strconv.Itoa(os.Getpid()), strconv.Itoa(index + 1), "recovered"}, ".")
+
+	data, err := encode_buffer()
+	if err != nil {
+		// Keep what can be kept, in UTF-8
+		data = []byte(strings.Join(buffer_lines(textBuffer), "\n"))
+	}
+	path = filepath.Join(dir, name)
+	return path, os.WriteFile(path, data, 0o600)
+}
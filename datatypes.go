@@ -1,68 +1,96 @@
-package main
-
-import (
-	termbox "github.com/nsf/termbox-go"
-)
-
-type stack struct {
-	// array of any type
-	contents []interface{}
-}
-
-// methods:
-
-// push, to add an extra value onto the array
-func (s *stack) push(value interface{}) {
-	s.contents = append(s.contents, value)
-}
-
-// pop, to return and remove the last item in the array
-func (s *stack) pop() interface{} {
-	if len(s.contents) == 0 {
-		return nil
-	}
-	value := s.contents[len(s.contents)-1]
-	s.contents = s.contents[:len(s.contents)-1]
-	return value
-}
-
-type CopyBuffer struct {
-	contents   [][]rune
-	bufferType string
-}
-
-// position tracks any position in the text buffer.
-type position struct {
-	row int
-	col int
-}
-
-type rulerState struct {
-	enabled bool
-	col     int
-}
-
-func new_ruler_state() rulerState {
-	if RULER_COL <= 0 {
-		return rulerState{enabled: false, col: -1}
-	}
-	return rulerState{enabled: true, col: RULER_COL - 1}
-}
-
-func (r rulerState) highlight(textBufferCol int) bool {
-	return r.enabled && textBufferCol == r.col
-}
-
-func (r rulerState) draw_for_short_line(cursorRow int, lineLen int, textCols int, gutterWidth int, offsetCol int) {
-	if !r.enabled || textCols <= 0 || r.col < lineLen {
-		return
-	}
-	if r.col < offsetCol || r.col >= offsetCol+textCols {
-		return
-	}
-	rulerScreenCol := gutterWidth + (r.col - offsetCol)
-	if rulerScreenCol < gutterWidth || rulerScreenCol >= COLS {
-		return
-	}
-	termbox.SetCell(rulerScreenCol, cursorRow, ' ', termbox.ColorDefault, RULER_BG)
-}
+package main
+
+import (
+	"crypto/sha256"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+type stack struct {
+	// array of any type
+	contents []interface{}
+}
+
+// methods:
+
+// push, to add an extra value onto the array
+func (s *stack) push(value interface{}) {
+	s.contents = append(s.contents, value)
+}
+
+// pop, to return and remove the last item in the array
+func (s *stack) pop() interface{} {
+	if len(s.contents) == 0 {
+		return nil
+	}
+	value := s.contents[len(s.contents)-1]
+	s.contents = s.contents[:len(s.contents)-1]
+	return value
+}
+
+type CopyBuffer struct {
+	contents   [][]rune
+	bufferType string
+}
+
+// indentStyle is how a buffer is indented.
+// With tabs, one level of indentation is a single tab.
+type indentStyle struct {
+	expandTab bool // indent with spaces instead of tabs
+	width     int  // spaces per indent level
+}
+
+// fileFormatState is how a buffer's lines are stored on disk,
+// so saving writes them back the way they were read.
+type fileFormatState struct {
+	lineEnding   string // "\n", "\r\n" or "\r"
+	finalNewline bool   // whether the last line ends with lineEnding
+	bom          bool   // whether the file starts with a byte order mark
+	encoding     string // how characters are stored, e.g. "utf-8" or "latin1"
+}
+
+// diskState is what the file on disk looked like when it was last read or saved
+type diskState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+	hash    [sha256.Size]byte
+}
+
+// position tracks any position in the text buffer.
+type position struct {
+	row int
+	col int
+}
+
+type rulerState struct {
+	enabled bool
+	col     int
+}
+
+func new_ruler_state() rulerState {
+	if RULER_COL <= 0 {
+		return rulerState{enabled: false, col: -1}
+	}
+	return rulerState{enabled: true, col: RULER_COL - 1}
+}
+
+// Ruler columns are screen columns, so tabs and wide characters count their full width
+func (r rulerState) highlight(visualCol int) bool {
+	return r.enabled && visualCol == r.col
+}
+
+func (r rulerState) draw_for_short_line(cursorRow int, lineWidth int, textCols int, gutterWidth int, offsetCol int) {
+	if !r.enabled || textCols <= 0 || r.col < lineWidth {
+		return
+	}
+	if r.col < offsetCol || r.col >= offsetCol+textCols {
+		return
+	}
+	rulerScreenCol := gutterWidth + (r.col - offsetCol)
+	if rulerScreenCol < gutterWidth || rulerScreenCol >= COLS {
+		return
+	}
+	termbox.SetCell(rulerScreenCol, cursorRow, ' ', termbox.ColorDefault, RULER_BG)
+}
@@ -0,0 +1,96 @@
+package main
+
+import termbox "github.com/nsf/termbox-go"
+
+// Past this many line pairs, the changed middle of two texts
+// is shown as all removed then all added, instead of matched up
+const MAX_DIFF_CELLS = 4_000_000
+
+// diff_lines compares before and after line by line, for showing in the pager
+func diff_lines(before []string, after []string) []pagerLine {
+	// Lines shared at the start and end need no matching
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	lines := []pagerLine{}
+	for _, line := range before[:prefix] {
+		lines = append(lines, diff_line(' ', line))
+	}
+	lines = append(lines, diff_middle(before[prefix:len(before)-suffix], after[prefix:len(after)-suffix])...)
+	for _, line := range before[len(before)-suffix:] {
+		lines = append(lines, diff_line(' ', line))
+	}
+	return lines
+}
+
+// diff_middle matches lines up by their longest common subsequence
+func diff_middle(before []string, after []string) []pagerLine {
+	lines := []pagerLine{}
+	if len(before)*len(after) > MAX_DIFF_CELLS {
+		for _, line := range before {
+			lines = append(lines, diff_line('-', line))
+		}
+		for _, line := range after {
+			lines = append(lines, diff_line('+', line))
+		}
+		return lines
+	}
+
+	// common[i][j] is the LCS length of before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			lines = append(lines, diff_line(' ', before[i]))
+			i++
+			j++
+		case i < len(before) && (j == len(after) || common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diff_line('-', before[i]))
+			i++
+		default:
+			lines = append(lines, diff_line('+', after[j]))
+			j++
+		}
+	}
+	return lines
+}
+
+func diff_line(kind rune, text string) pagerLine {
+	switch kind {
+	case '-':
+		return pagerLine{text: "- " + text, fg: termbox.ColorRed}
+	case '+':
+		return pagerLine{text: "+ " + text, fg: termbox.ColorGreen}
+	}
+	return pagerLine{text: "  " + text, fg: termbox.ColorDefault}
+}
+
+// buffer_lines is the text buffer as strings, for diffing
+func buffer_lines(buffer [][]rune) []string {
+	lines := make([]string, len(buffer))
+	for i, line := range buffer {
+		lines[i] = line_string(line)
+	}
+	return lines
+}
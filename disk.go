@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// The file on disk is checked for changes made by other programs
+// when the terminal regains focus, before saving, and every DISK_CHECK_INTERVAL
+
+var (
+	diskFile      diskState
+	diskLastCheck time.Time
+
+	errFileChanged = errors.New("file changed on disk since it was read")
+)
+
+func read_disk_state(path string) (diskState, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return diskState{}, nil
+	}
+	if err != nil {
+		return diskState{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return diskState{}, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return diskState{}, err
+	}
+
+	state := diskState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	copy(state.hash[:], hash.Sum(nil))
+	return state, nil
+}
+
+// disk_changed reports whether the file's contents differ from when it was last
+// read or saved. The hash is only checked when the size or time has changed,
+// and a file that was only touched just has its new time remembered.
+func disk_changed() (diskState, bool) {
+	path := filename + fileExtension
+	info, err := os.Stat(path)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Can't tell, so don't get in the way
+		return diskFile, false
+	}
+	if exists == diskFile.exists && (!exists || info.Size() == diskFile.size && info.ModTime().Equal(diskFile.modTime)) {
+		return diskFile, false
+	}
+
+	// Large files are too slow to hash, so any change in size or time counts
+	if largeFile {
+		state := diskState{exists: exists}
+		if exists {
+			state.size, state.modTime = info.Size(), info.ModTime()
+		}
+		return state, true
+	}
+
+	state, err := read_disk_state(path)
+	if err != nil {
+		return diskFile, false
+	}
+	if state.exists == diskFile.exists && state.hash == diskFile.hash {
+		diskFile = state
+		return state, false
+	}
+	return state, true
+}
+
+func check_disk_file() {
+	diskLastCheck = time.Now()
+	// Pipes and unnamed buffers have no file on disk
+	if promptActive || pagerActive || pipeMode || filename+fileExtension == "" {
+		return
+	}
+	state, changed := disk_changed()
+	if !changed {
+		return
+	}
+
+	path := filename + fileExtension
+	switch {
+	case !state.exists:
+		// The buffer is all that's left, so it now needs saving
+		diskFile = state
+		modified = true
+		bufferVersion++
+		show_warning(path + " was deleted on disk, save to write it again")
+
+	case !modified && largeFile && grow_large_file(state):
+		// Lines added to the end are picked up quietly, like following a log
+
+	case !modified:
+		reload_file()
+		show_info("reloaded " + path + ", it changed on disk")
+
+	default:
+		prompt_external_change()
+	}
+}
+
+func poll_disk_file() {
+	if time.Since(diskLastCheck) >= DISK_CHECK_INTERVAL {
+		check_disk_file()
+	}
+}
+
+// reload_file reads the file again, in the encoding it's being edited in,
+// or into the hex view if that's where it's being edited
+func reload_file() {
+	if hexActive {
+		reload_hex()
+		return
+	}
+	reload_file_as(fileFormat.encoding)
+}
+
+func reload_file_as(encoding string) {
+	read_file_as(filename+fileExtension, encoding)
+	modified = false
+	bufferVersion++
+	go_to_position(position{row: currentRow, col: currentCol})
+	mark_viewport_dirty()
+}
+
+// ---------- Resolving Changes ----------
+
+func prompt_external_change() {
+	open_prompt(filename+fileExtension+" changed on disk:", []promptChoice{
+		{key: 'r', label: "reload", action: reload_file},
+		{key: 'o', label: "overwrite", action: overwrite_file},
+		{key: 'd', label: "diff", action: diff_disk_file},
+		{key: 'k', label: "keep editing", action: keep_buffer},
+	})
+}
+
+func overwrite_file() {
+	// Accept what's on disk now as what's being replaced
+	keep_buffer()
+	write_file(filename, fileExtension)
+}
+
+// keep_buffer stops asking about this change, until the file changes again
+func keep_buffer() {
+	if state, err := read_disk_state(filename + fileExtension); err == nil {
+		diskFile = state
+	}
+}
+
+func diff_disk_file() {
+	data, err := os.ReadFile(filename + fileExtension)
+	if err != nil {
+		show_error(err.Error())
+		prompt_external_change()
+		return
+	}
+	if hexActive {
+		open_pager("changes on disk", diff_lines(hex_dump_lines(hexData), hex_dump_lines(data)))
+	} else {
+		lines, _, _ := read_lines(bytes.NewReader(decode_text(data, fileFormat.encoding)), nil)
+		open_pager("changes on disk", diff_lines(buffer_lines(textBuffer), buffer_lines(lines)))
+	}
+	pagerOnClose = prompt_external_change
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The buffer always holds text as UTF-8 runes. Files in other encodings
+// are decoded when read, and encoded back the same way when saved.
+//
+// UTF-16 that can't be decoded, an unpaired surrogate or a last odd byte,
+// is kept as the three byte UTF-8 form of its value. That isn't valid UTF-8,
+// so it's held as raw bytes (see rawbytes.go) and written back as it was.
+
+const (
+	ENCODING_UTF8    = "utf-8"
+	ENCODING_UTF16LE = "utf-16le"
+	ENCODING_UTF16BE = "utf-16be"
+	ENCODING_LATIN1  = "latin1"
+)
+
+// How much of a file the UTF-16 guess looks at
+const ENCODING_DETECT_BYTES = 64 * 1024
+
+// parse_encoding accepts an encoding's name or a common alias for it
+func parse_encoding(name string) (string, bool) {
+	switch name {
+	case "utf-8", "utf8", "UTF-8":
+		return ENCODING_UTF8, true
+	case "utf-16le", "utf16le", "UTF-16LE":
+		return ENCODING_UTF16LE, true
+	case "utf-16be", "utf16be", "UTF-16BE":
+		return ENCODING_UTF16BE, true
+	case "latin1", "latin-1", "iso-8859-1", "ISO-8859-1":
+		return ENCODING_LATIN1, true
+	}
+	return "", false
+}
+
+// detect_encoding goes by the byte order mark if there is one, then guesses:
+// UTF-16 text is full of zero bytes on one side of each pair,
+// and anything that isn't mostly UTF-8 is taken as Latin-1
+func detect_encoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		return ENCODING_UTF8
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return ENCODING_UTF16LE
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return ENCODING_UTF16BE
+	}
+
+	sample := data
+	if len(sample) > ENCODING_DETECT_BYTES {
+		sample = sample[:ENCODING_DETECT_BYTES]
+	}
+	if len(sample) >= 2 && len(data)%2 == 0 {
+		evenZeros, oddZeros := 0, 0
+		for i := 0; i+1 < len(sample); i += 2 {
+			if sample[i] == 0 {
+				evenZeros++
+			}
+			if sample[i+1] == 0 {
+				oddZeros++
+			}
+		}
+		pairs := len(sample) / 2
+		if oddZeros*10 >= pairs*3 && evenZeros*20 < pairs {
+			return ENCODING_UTF16LE
+		}
+		if evenZeros*10 >= pairs*3 && oddZeros*20 < pairs {
+			return ENCODING_UTF16BE
+		}
+	}
+
+	if looks_like_utf8(data) {
+		return ENCODING_UTF8
+	}
+	return ENCODING_LATIN1
+}
+
+// looks_like_utf8 allows a few invalid bytes in otherwise UTF-8 text,
+// which are kept as they are (see rawbytes.go). Text with more invalid
+// bytes than characters outside ASCII is more likely Latin-1.
+func looks_like_utf8(data []byte) bool {
+	multiByte, invalid := 0, 0
+	for i := 0; i < len(data); {
+		ch, size := utf8.DecodeRune(data[i:])
+		switch {
+		case ch == utf8.RuneError && size == 1:
+			invalid++
+		case size > 1:
+			multiByte++
+		}
+		i += size
+	}
+	return invalid == 0 || multiByte > invalid
+}
+
+// decode_text turns data in the given encoding into UTF-8
+func decode_text(data []byte, encoding string) []byte {
+	switch encoding {
+	case ENCODING_UTF16LE, ENCODING_UTF16BE:
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if encoding == ENCODING_UTF16LE {
+				units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+			} else {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			}
+		}
+		text := make([]byte, 0, len(data))
+		for i := 0; i < len(units); i++ {
+			unit := rune(units[i])
+			switch {
+			case !utf16.IsSurrogate(unit):
+				text = utf8.AppendRune(text, unit)
+			case i+1 < len(units) && utf16.DecodeRune(unit, rune(units[i+1])) != utf8.RuneError:
+				text = utf8.AppendRune(text, utf16.DecodeRune(unit, rune(units[i+1])))
+				i++
+			default:
+				text = append_unit_bytes(text, unit)
+			}
+		}
+		if len(data)%2 != 0 {
+			text = append_unit_bytes(text, rune(data[len(data)-1]))
+		}
+		return text
+
+	case ENCODING_LATIN1:
+		// Every byte is the code point of the same number
+		text := make([]byte, 0, len(data))
+		for _, b := range data {
+			text = utf8.AppendRune(text, rune(b))
+		}
+		return text
+	}
+	return data
+}
+
+// append_unit_bytes writes a UTF-16 code unit, or a single byte,
+// as three bytes the way UTF-8 would if it allowed them
+func append_unit_bytes(text []byte, unit rune) []byte {
+	return append(text, 0xE0|byte(unit>>12), 0x80|byte(unit>>6)&0x3F, 0x80|byte(unit)&0x3F)
+}
+
+// unit_bytes reads back what append_unit_bytes wrote, if text starts with it
+func unit_bytes(text []byte) (rune, bool) {
+	if len(text) < 3 || text[0]&0xF0 != 0xE0 || text[1]&0xC0 != 0x80 || text[2]&0xC0 != 0x80 {
+		return 0, false
+	}
+	unit := rune(text[0]&0x0F)<<12 | rune(text[1]&0x3F)<<6 | rune(text[2]&0x3F)
+	return unit, utf16.IsSurrogate(unit) || unit <= 0xFF
+}
+
+// encode_text turns UTF-8 text back into the given encoding,
+// failing if it has characters the encoding can't hold
+func encode_text(text []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case ENCODING_UTF16LE, ENCODING_UTF16BE:
+		data := make([]byte, 0, 2*len(text))
+		put_unit := func(unit rune) {
+			if encoding == ENCODING_UTF16LE {
+				data = append(data, byte(unit), byte(unit>>8))
+			} else {
+				data = append(data, byte(unit>>8), byte(unit))
+			}
+		}
+		line := 1
+		for i := 0; i < len(text); {
+			ch, size := utf8.DecodeRune(text[i:])
+			if ch == utf8.RuneError && size <= 1 {
+				unit, ok := unit_bytes(text[i:])
+				switch {
+				case !ok:
+					return nil, errors.New("line " + strconv.Itoa(line) + " has <" + hex_byte(text[i]) + ">, which " + encoding + " can't hold")
+				case unit <= 0xFF:
+					// The odd byte a file ended with
+					data = append(data, byte(unit))
+				default:
+					put_unit(unit)
+				}
+				i += 3
+				continue
+			}
+			if ch == '\n' {
+				line++
+			}
+			if first, second := utf16.EncodeRune(ch); first != utf8.RuneError {
+				put_unit(first)
+				put_unit(second)
+			} else {
+				put_unit(ch)
+			}
+			i += size
+		}
+		return data, nil
+
+	case ENCODING_LATIN1:
+		data := make([]byte, 0, len(text))
+		line := 1
+		for _, ch := range string(text) {
+			if ch > 0xFF {
+				return nil, errors.New("line " + strconv.Itoa(line) + " has " + strconv.QuoteRune(ch) + ", which latin1 can't hold")
+			}
+			if ch == '\n' {
+				line++
+			}
+			data = append(data, byte(ch))
+		}
+		return data, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// render_row writes a row the way it's drawn, like "^M" or "<FF>"
+// for what can't be shown as itself
+func render_row(line []rune) string {
+	var text strings.Builder
+	for _, ch := range line {
+		if display := rune_display(ch); display != "" {
+			text.WriteString(display)
+		} else {
+			text.WriteRune(ch)
+		}
+	}
+	return text.String()
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format fileFormatState
+		rows   []string
+	}{
+		{
+			name:   "LF",
+			data:   "a\nb\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a", "b"},
+		},
+		{
+			name:   "CRLF",
+			data:   "a\r\nb\r\n",
+			format: fileFormatState{lineEnding: "\r\n", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a", "b"},
+		},
+		{
+			name:   "mixed CRLF keeps the CRs",
+			data:   "a\r\nb\nc\r\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a^M", "b", "c^M"},
+		},
+		{
+			name:   "CR only",
+			data:   "a\rb\r",
+			format: fileFormatState{lineEnding: "\r", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a", "b"},
+		},
+		{
+			name:   "no final newline",
+			data:   "a\r\nb",
+			format: fileFormatState{lineEnding: "\r\n", encoding: ENCODING_UTF8},
+			rows:   []string{"a", "b"},
+		},
+		{
+			name:   "empty",
+			data:   "",
+			format: fileFormatState{lineEnding: "\n", encoding: ENCODING_UTF8},
+			rows:   []string{""},
+		},
+		{
+			name:   "BOM",
+			data:   "\xEF\xBB\xBFa\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, bom: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a"},
+		},
+		{
+			name:   "invalid UTF-8",
+			data:   "é é \xFF\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"é é <FF>"},
+		},
+		{
+			name:   "control bytes",
+			data:   "a\x1B[0m\x7F\u0085\tb\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, encoding: ENCODING_UTF8},
+			rows:   []string{"a^[[0m^?<U+0085>\tb"},
+		},
+		{
+			name:   "Latin-1",
+			data:   "caf\xE9\n\xFF",
+			format: fileFormatState{lineEnding: "\n", encoding: ENCODING_LATIN1},
+			rows:   []string{"café", "ÿ"},
+		},
+		{
+			name:   "UTF-16LE with a BOM",
+			data:   "\xFF\xFEa\x00\r\x00\n\x00\x3D\xD8\x00\xDE",
+			format: fileFormatState{lineEnding: "\r\n", bom: true, encoding: ENCODING_UTF16LE},
+			rows:   []string{"a", "😀"},
+		},
+		{
+			name:   "UTF-16BE without a BOM",
+			data:   "\x00a\x00\n\x00b\x00\n",
+			format: fileFormatState{lineEnding: "\n", finalNewline: true, encoding: ENCODING_UTF16BE},
+			rows:   []string{"a", "b"},
+		},
+		{
+			name:   "UTF-16 unpaired surrogates",
+			data:   "\xFF\xFEa\x00\x00\xD8\n\x00\x00\xDC",
+			format: fileFormatState{lineEnding: "\n", bom: true, encoding: ENCODING_UTF16LE},
+			rows:   []string{"a<ED><A0><80>", "<ED><B0><80>"},
+		},
+		{
+			name:   "UTF-16 odd last byte",
+			data:   "\xFF\xFEa\x00x",
+			format: fileFormatState{lineEnding: "\n", bom: true, encoding: ENCODING_UTF16LE},
+			rows:   []string{"a<E0><81><B8>"},
+		},
+	}
+
+	defer func() {
+		textBuffer, fileFormat = nil, fileFormatState{}
+	}()
+	for _, test := range tests {
+		load_data([]byte(test.data), "")
+		if hexActive {
+			t.Errorf("%s: opened in the hex view", test.name)
+			close_hex()
+			continue
+		}
+		if fileFormat != test.format {
+			t.Errorf("%s: format = %+v, want %+v", test.name, fileFormat, test.format)
+		}
+		var rows []string
+		for _, line := range textBuffer {
+			rows = append(rows, render_row(line))
+		}
+		if !slices.Equal(rows, test.rows) {
+			t.Errorf("%s: rows = %q, want %q", test.name, rows, test.rows)
+		}
+
+		saved, err := encode_buffer()
+		if err != nil {
+			t.Errorf("%s: saving: %v", test.name, err)
+		} else if !bytes.Equal(saved, []byte(test.data)) {
+			t.Errorf("%s: saved as %q, want %q", test.name, saved, test.data)
+		}
+	}
+}
+
+func TestEncodeUnencodable(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding string
+		row      []rune
+		want     string
+	}{
+		{"beyond Latin-1", ENCODING_LATIN1, []rune("aā"), `line 1 has 'ā', which latin1 can't hold`},
+		{"raw byte in UTF-16", ENCODING_UTF16LE, []rune{'a', raw_byte_rune(0xFF)}, "line 1 has <FF>, which utf-16le can't hold"},
+	}
+
+	defer func() {
+		textBuffer, fileFormat = nil, fileFormatState{}
+	}()
+	for _, test := range tests {
+		textBuffer = [][]rune{test.row}
+		fileFormat = fileFormatState{lineEnding: "\n", encoding: test.encoding}
+		_, err := encode_buffer()
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.want)
+		}
+	}
+}
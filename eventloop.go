@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Everything that touches the buffers runs on one goroutine, the UI goroutine.
+// Between redraws it waits for whichever comes first: a terminal event, a signal,
+// the timed work tick, or a func posted by a timer or background goroutine.
+// Background work never changes the buffers itself, it posts a func that does.
+
+// How many posted funcs can wait before posting blocks
+const UI_QUEUE_SIZE = 64
+
+var (
+	termEvents = make(chan termbox.Event)
+	uiQueue    = make(chan func(), UI_QUEUE_SIZE)
+
+	timedWork *time.Ticker
+)
+
+// poll_terminal hands terminal events over to the UI goroutine
+func poll_terminal() {
+	defer forward_panic()
+	for {
+		termEvents <- termbox.PollEvent()
+	}
+}
+
+func start_event_loop() {
+	timedWork = time.NewTicker(WAKE_INTERVAL)
+	watch_signals()
+	go poll_terminal()
+}
+
+// handle_next waits for the next event and handles it
+func handle_next() {
+	// Keys read ahead while looking for a focus report come first
+	if len(pendingEvents) > 0 {
+		event := pendingEvents[0]
+		pendingEvents = pendingEvents[1:]
+		process_key(event)
+		return
+	}
+
+	select {
+	case event := <-termEvents:
+		process_key(event)
+	case sig := <-signals:
+		handle_signal(sig)
+	case fn := <-uiQueue:
+		fn()
+	case <-timedWork.C:
+		do_timed_work()
+	}
+}
+
+// do_timed_work runs every WAKE_INTERVAL. Each job keeps its own pace.
+func do_timed_work() {
+	journal_swap()
+	poll_disk_file()
+	auto_save_idle()
+}
+
+// ---------- Posting Work ----------
+
+// post runs fn on the UI goroutine. Only other goroutines can post,
+// as the UI goroutine would wait on itself if the queue was full.
+func post(fn func()) {
+	uiQueue <- fn
+}
+
+// after runs fn on the UI goroutine once d has passed, unless stopped first
+func after(d time.Duration, fn func()) *time.Timer {
+	return time.AfterFunc(d, func() { post(fn) })
+}
+
+// A task is work running in the background, which can be cancelled.
+// What it posts is dropped once it's cancelled.
+type task struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func start_task(work func(t *task)) *task {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &task{ctx: ctx, cancel: cancel}
+	go func() {
+		defer forward_panic()
+		work(t)
+	}()
+	return t
+}
+
+func (t *task) cancelled() bool {
+	return t.ctx.Err() != nil
+}
+
+// post runs fn on the UI goroutine, if the task still hasn't been cancelled by then
+func (t *task) post(fn func()) {
+	if t.cancelled() {
+		return
+	}
+	post(func() {
+		if !t.cancelled() {
+			fn()
+		}
+	})
+}
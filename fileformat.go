@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// The byte order mark some editors put at the start of UTF-8 files.
+// Other encodings' marks are decoded to this, so are handled the same way.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+var fileFormat = new_file_format()
+
+func new_file_format() fileFormatState {
+	return fileFormatState{lineEnding: NEW_FILE_LINE_ENDING, finalNewline: NEW_FILE_FINAL_NEWLINE, encoding: ENCODING_UTF8}
+}
+
+// line_ending_name is the short name shown in the status bar
+func line_ending_name(lineEnding string) string {
+	switch lineEnding {
+	case "\r\n":
+		return "CRLF"
+	case "\r":
+		return "CR"
+	default:
+		return "LF"
+	}
+}
+
+// parse_line_ending accepts either a name or the common aliases for one
+func parse_line_ending(name string) (string, bool) {
+	switch name {
+	case "lf", "LF", "unix":
+		return "\n", true
+	case "crlf", "CRLF", "dos":
+		return "\r\n", true
+	case "cr", "CR", "mac":
+		return "\r", true
+	}
+	return "", false
+}
+
+func (f fileFormatState) status() string {
+	status := f.encoding + " " + line_ending_name(f.lineEnding)
+	if f.bom {
+		status += " BOM"
+	}
+	if !f.finalNewline {
+		status += " noeol"
+	}
+	return status
+}
+
+// split_cr_lines splits a file that only uses "\r" between lines,
+// which reads in as a single line. The bool is whether it ended with one.
+func split_cr_lines(line []rune) ([][]rune, bool) {
+	lines := [][]rune{}
+	start := 0
+	for i, ch := range line {
+		if ch == '\r' {
+			lines = append(lines, line[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(line) {
+		lines = append(lines, line[start:])
+		return lines, false
+	}
+	return lines, true
+}
+
+// strip_trailing_cr takes the '\r' off lines left over from a mixed file,
+// so converting it gives every line the same ending
+func strip_trailing_cr() {
+	for row, line := range textBuffer {
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			textBuffer[row] = line[:len(line)-1]
+			mark_line_dirty(row)
+		}
+	}
+}
+
+// set_file_format changes how the buffer is saved, which counts as a modification
+func set_file_format(format fileFormatState) {
+	if format != fileFormat {
+		fileFormat = format
+		modified = true
+		bufferVersion++
+	}
+}
+
+// read_lines splits a file into lines, reusing dst's space,
+// and works out how the file stored them
+func read_lines(file io.Reader, dst [][]rune) ([][]rune, fileFormatState, error) {
+	lines := append(dst[:0], []rune{})
+
+	reader := bufio.NewReader(file)
+	lineNumber := 0
+	var readErr error
+
+	format := fileFormatState{lineEnding: "\n", encoding: ENCODING_UTF8}
+	if bom, _ := reader.Peek(len(utf8BOM)); bytes.Equal(bom, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+		format.bom = true
+	}
+	crlfCount, lfCount := 0, 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			readErr = err
+			break
+		}
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+
+		format.finalNewline = false
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+			format.finalNewline = true
+			// The '\r' is only taken off below, once the file is known to be all CRLF
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				crlfCount++
+			} else {
+				lfCount++
+			}
+		}
+
+		lines[lineNumber] = appendLineRunes(lines[lineNumber], line)
+		lines = append(lines, []rune{})
+		lineNumber++
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Every line read adds a row for the next, so the last one is spare,
+	// unless it's an empty file which needs a single empty row to not crash
+	if lineNumber > 0 {
+		lines = lines[:lineNumber]
+	}
+
+	// A file is only CRLF if every line is. In a mixed file, the '\r' of
+	// the CRLF lines stays in the line, so it's saved exactly as it was.
+	if crlfCount > 0 && lfCount == 0 {
+		format.lineEnding = "\r\n"
+		terminated := lineNumber
+		if !format.finalNewline {
+			terminated--
+		}
+		for row := 0; row < terminated; row++ {
+			lines[row] = lines[row][:len(lines[row])-1]
+		}
+	}
+	if crlfCount == 0 && lfCount == 0 && lineNumber == 1 {
+		if crLines, finalNewline := split_cr_lines(lines[0]); len(crLines) > 1 || finalNewline {
+			lines = crLines
+			format.lineEnding = "\r"
+			format.finalNewline = finalNewline
+		}
+	}
+	return lines, format, readErr
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Terminals that support it report gaining and losing focus as "\x1b[I" and "\x1b[O".
+// termbox doesn't know these, and hands them over as Esc, '[' then 'I' or 'O'.
+
+// How long to wait after an Esc for the rest of a focus report
+const ESC_SEQUENCE_TIMEOUT = 25 * time.Millisecond
+
+var (
+	focusTTY *os.File
+	focused  = true
+
+	// Keys read while looking for a focus report, to be handled next
+	pendingEvents []termbox.Event
+)
+
+func enable_focus_reporting() {
+	if !FOCUS_EVENTS {
+		return
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	focusTTY = tty
+	focusTTY.WriteString("\x1b[?1004h")
+}
+
+func disable_focus_reporting() {
+	if focusTTY == nil {
+		return
+	}
+	focusTTY.WriteString("\x1b[?1004l")
+	focusTTY.Close()
+	focusTTY = nil
+}
+
+// read_focus_event checks whether an Esc key is the start of a focus report,
+// putting back anything read after it that isn't
+func read_focus_event(event termbox.Event) (focusIn bool, ok bool) {
+	if focusTTY == nil || event.Key != termbox.KeyEsc || len(pendingEvents) > 0 {
+		return false, false
+	}
+
+	bracket, got := poll_within(ESC_SEQUENCE_TIMEOUT)
+	if !got {
+		return false, false
+	}
+	if bracket.Ch != '[' {
+		pendingEvents = append(pendingEvents, bracket)
+		return false, false
+	}
+
+	final, got := poll_within(ESC_SEQUENCE_TIMEOUT)
+	if !got {
+		pendingEvents = append(pendingEvents, bracket)
+		return false, false
+	}
+	if final.Ch == 'I' || final.Ch == 'O' {
+		return final.Ch == 'I', true
+	}
+	pendingEvents = append(pendingEvents, bracket, final)
+	return false, false
+}
+
+// poll_within waits a short time for a key, and reports whether one came.
+// Other events that come instead are kept to be handled next.
+func poll_within(timeout time.Duration) (termbox.Event, bool) {
+	select {
+	case event := <-termEvents:
+		if event.Type != termbox.EventKey {
+			pendingEvents = append(pendingEvents, event)
+			return event, false
+		}
+		return event, true
+	case <-time.After(timeout):
+		return termbox.Event{}, false
+	}
+}
+
+func handle_focus(focusIn bool) {
+	focused = focusIn
+	if focusIn {
+		check_disk_file()
+	} else if AUTO_SAVE_ON_FOCUS_LOSS {
+		auto_save_all()
+	}
+}
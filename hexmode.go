@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// The hex view edits a file's bytes directly, for binary files
+// that would be mangled as text. Each row shows an offset,
+// then HEX_BYTES_PER_ROW bytes in hex, then the same bytes as ASCII.
+
+const (
+	HEX_BYTES_PER_ROW = 16
+
+	// How much of a file is checked when deciding if it's binary
+	HEX_DETECT_BYTES = 8000
+
+	HEX_PANE_HEX   = 0
+	HEX_PANE_ASCII = 1
+)
+
+// Screen columns of the parts of a row
+const (
+	HEX_COL_BYTES = 10
+	HEX_COL_ASCII = HEX_COL_BYTES + HEX_BYTES_PER_ROW*3 + 3
+)
+
+var (
+	hexActive bool
+	hexData   []byte
+
+	hexCursor int // byte under the cursor
+	hexOffset int // first row on screen
+	hexPane   int
+	hexNibble int // 0 for the high half of the byte, 1 for the low
+
+	hexLastPattern []byte
+)
+
+// is_binary guesses whether data is binary rather than text,
+// by looking for NUL bytes, or lots of control characters
+func is_binary(data []byte) bool {
+	switch detect_encoding(data) {
+	case ENCODING_UTF16LE, ENCODING_UTF16BE:
+		// UTF-16 text is full of NUL bytes
+		return false
+	}
+
+	sample := data
+	if len(sample) > HEX_DETECT_BYTES {
+		sample = sample[:HEX_DETECT_BYTES]
+	}
+	controls := 0
+	for _, b := range sample {
+		switch {
+		case b == 0:
+			return true
+		case b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\b' && b != 0x1B:
+			controls++
+		}
+	}
+	return controls*10 > len(sample)
+}
+
+func open_hex(data []byte) {
+	hexActive = true
+	hexData = data
+	hexCursor = 0
+	hexOffset = 0
+	hexPane = HEX_PANE_HEX
+	hexNibble = 0
+
+	// The text buffer sits empty underneath
+	textBuffer = [][]rune{{}}
+	currentRow, currentCol = 0, 0
+	fileFormat = new_file_format()
+	mark_viewport_dirty()
+}
+
+// reload_hex reads the file's bytes again, keeping the cursor where it was
+func reload_hex() {
+	path := filename + fileExtension
+	data, err := os.ReadFile(path)
+	if err != nil {
+		show_error(err.Error())
+		return
+	}
+	if state, err := read_disk_state(path); err == nil {
+		diskFile = state
+	}
+
+	cursor, offset := hexCursor, hexOffset
+	open_hex(data)
+	hexCursor = min(cursor, max(len(data)-1, 0))
+	hexOffset = offset
+	modified = false
+	bufferVersion++
+}
+
+func close_hex() {
+	if !hexActive {
+		return
+	}
+	hexActive = false
+	hexData = nil
+	mark_viewport_dirty()
+}
+
+// toggle_hex switches the buffer between the hex view and text,
+// carrying over any edits. It only changes the view, so works on read-only
+// buffers too, but not large files, which are never all in memory.
+func toggle_hex() error {
+	if largeFile {
+		return errors.New("hex: " + errLargeFile.Error())
+	}
+	if hexActive {
+		data := hexData
+		close_hex()
+		load_text(data, "")
+		go_to_position(position{row: currentRow, col: currentCol})
+		return nil
+	}
+
+	data, err := encode_buffer()
+	if err != nil {
+		return err
+	}
+	wasModified := modified
+	open_hex(data)
+	modified = wasModified
+	return nil
+}
+
+// ---------- Keys ----------
+
+// handle_hex_key moves around and edits in the hex view. Keys it doesn't use,
+// like saving, quitting and the command line, are left for process_key.
+func handle_hex_key(event termbox.Event) bool {
+	switch {
+	case event.Key == TOGGLE_MODE_KEY, event.Key == SAVE_NOQUIT, event.Key == SUSPEND_KEY:
+		return false
+	case mode == 0 && (event.Ch == COMMAND_KEY || event.Ch == QUIT_SAVE || event.Ch == QUIT_NOSAVE || event.Ch == FORCE_QUIT):
+		return false
+
+	case event.Key == termbox.KeyArrowLeft, mode == 0 && event.Ch == CURSOR_LEFT:
+		hex_move(-1)
+	case event.Key == termbox.KeyArrowRight, mode == 0 && event.Ch == CURSOR_RIGHT:
+		hex_move(1)
+	case event.Key == termbox.KeyArrowUp, mode == 0 && event.Ch == CURSOR_UP:
+		hex_move(-HEX_BYTES_PER_ROW)
+	case event.Key == termbox.KeyArrowDown, mode == 0 && event.Ch == CURSOR_DOWN:
+		hex_move(HEX_BYTES_PER_ROW)
+	case event.Key == PAGE_UP:
+		hex_move(-ROWS * HEX_BYTES_PER_ROW)
+	case event.Key == PAGE_DOWN:
+		hex_move(ROWS * HEX_BYTES_PER_ROW)
+	case event.Key == START_OF_LINE:
+		hex_move(-(hexCursor % HEX_BYTES_PER_ROW))
+	case event.Key == END_OF_LINE:
+		hex_move(HEX_BYTES_PER_ROW - 1 - hexCursor%HEX_BYTES_PER_ROW)
+	case mode == 0 && event.Ch == JUMP_UP:
+		hex_move(-hexCursor)
+	case mode == 0 && event.Ch == JUMP_DOWN:
+		hex_move(len(hexData))
+
+	// Switch between the hex and ASCII columns
+	case event.Key == termbox.KeyTab:
+		hexPane = 1 - hexPane
+		hexNibble = 0
+
+	case mode == 1 && (event.Key == termbox.KeyBackspace || event.Key == termbox.KeyBackspace2):
+		hex_move(-1)
+	case mode == 1 && event.Key == termbox.KeySpace:
+		hex_overwrite(' ')
+	case mode == 1 && event.Ch != 0:
+		hex_overwrite(event.Ch)
+	}
+	return true
+}
+
+// hex_move moves the cursor by delta bytes. It can sit just past the end,
+// where typing adds bytes to the file.
+func hex_move(delta int) {
+	hexCursor += delta
+	if hexCursor > len(hexData) {
+		hexCursor = len(hexData)
+	}
+	if hexCursor < 0 {
+		hexCursor = 0
+	}
+	hexNibble = 0
+}
+
+// hex_overwrite replaces the byte under the cursor, half a byte at a time
+// in the hex column, or with an ASCII character in the other
+func hex_overwrite(ch rune) {
+	var value byte
+	if hexPane == HEX_PANE_HEX {
+		digit, err := strconv.ParseUint(string(ch), 16, 8)
+		if err != nil {
+			return
+		}
+		value = byte(digit)
+	} else {
+		if ch < 0x20 || ch > 0x7E {
+			return
+		}
+		value = byte(ch)
+	}
+
+	if hexCursor == len(hexData) {
+		hexData = append(hexData, 0)
+	}
+	switch {
+	case hexPane == HEX_PANE_ASCII:
+		hexData[hexCursor] = value
+		hexCursor++
+	case hexNibble == 0:
+		hexData[hexCursor] = value<<4 | hexData[hexCursor]&0x0F
+		hexNibble = 1
+	default:
+		hexData[hexCursor] = hexData[hexCursor]&0xF0 | value
+		hexNibble = 0
+		hexCursor++
+	}
+	modified = true
+	bufferVersion++
+}
+
+// ---------- Searching ----------
+
+// parse_byte_pattern reads hex digits like "de ad be ef",
+// or text in double quotes like "\"PNG\""
+func parse_byte_pattern(text string) ([]byte, error) {
+	if len(text) >= 2 && strings.HasPrefix(text, "\"") && strings.HasSuffix(text, "\"") {
+		return []byte(text[1 : len(text)-1]), nil
+	}
+	pattern, err := hex.DecodeString(strings.ReplaceAll(text, " ", ""))
+	if err != nil || len(pattern) == 0 {
+		return nil, errors.New("not a byte pattern: " + text)
+	}
+	return pattern, nil
+}
+
+// hex_find moves to the next match after the cursor, wrapping around at the end
+func hex_find(pattern []byte) bool {
+	start := min(hexCursor+1, len(hexData))
+	index := bytes.Index(hexData[start:], pattern)
+	if index >= 0 {
+		index += start
+	} else {
+		index = bytes.Index(hexData, pattern)
+	}
+	if index < 0 {
+		return false
+	}
+	hexCursor = index
+	hexNibble = 0
+	return true
+}
+
+// ---------- Drawing ----------
+
+func hex_scroll() {
+	row := hexCursor / HEX_BYTES_PER_ROW
+	if row < hexOffset {
+		hexOffset = row
+	}
+	if row >= hexOffset+ROWS {
+		hexOffset = row - ROWS + 1
+	}
+}
+
+func hex_byte_col(i int) int {
+	// An extra space splits each row in half
+	col := HEX_COL_BYTES + i*3
+	if i >= HEX_BYTES_PER_ROW/2 {
+		col++
+	}
+	return col
+}
+
+func display_hex() {
+	hex_scroll()
+	for row := 0; row < ROWS; row++ {
+		clear_screen_row(row)
+		start := (hexOffset + row) * HEX_BYTES_PER_ROW
+		if start > len(hexData) {
+			// Indicate EoF
+			termbox.SetCell(0, row, '*', termbox.ColorBlue, termbox.ColorDefault)
+			continue
+		}
+
+		print_message(0, row, termbox.ColorYellow, termbox.ColorDefault, hex_offset(start))
+		termbox.SetCell(HEX_COL_ASCII-1, row, '|', termbox.ColorBlue, termbox.ColorDefault)
+		termbox.SetCell(HEX_COL_ASCII+HEX_BYTES_PER_ROW, row, '|', termbox.ColorBlue, termbox.ColorDefault)
+
+		for i := 0; i < HEX_BYTES_PER_ROW && start+i < len(hexData); i++ {
+			b := hexData[start+i]
+
+			// The cursor's byte is also marked in the other column
+			hexFg, asciiFg := termbox.ColorDefault, termbox.ColorDefault
+			if start+i == hexCursor {
+				if hexPane == HEX_PANE_HEX {
+					asciiFg |= termbox.AttrReverse
+				} else {
+					hexFg |= termbox.AttrReverse
+				}
+			}
+
+			print_message(hex_byte_col(i), row, hexFg, termbox.ColorDefault, hex_byte(b))
+			ch := '.'
+			if b >= 0x20 && b <= 0x7E {
+				ch = rune(b)
+			}
+			termbox.SetCell(HEX_COL_ASCII+i, row, ch, asciiFg, termbox.ColorDefault)
+		}
+	}
+}
+
+// hex_dump_lines lays data out the way the hex view shows it, a line per row
+func hex_dump_lines(data []byte) []string {
+	var lines []string
+	for start := 0; start < len(data); start += HEX_BYTES_PER_ROW {
+		line := []rune(strings.Repeat(" ", HEX_COL_ASCII+HEX_BYTES_PER_ROW+1))
+		copy(line, []rune(hex_offset(start)))
+		line[HEX_COL_ASCII-1] = '|'
+		line[HEX_COL_ASCII+HEX_BYTES_PER_ROW] = '|'
+
+		for i := 0; i < HEX_BYTES_PER_ROW && start+i < len(data); i++ {
+			b := data[start+i]
+			copy(line[hex_byte_col(i):], []rune(hex_byte(b)))
+			line[HEX_COL_ASCII+i] = '.'
+			if b >= 0x20 && b <= 0x7E {
+				line[HEX_COL_ASCII+i] = rune(b)
+			}
+		}
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+func hex_offset(offset int) string {
+	text := strings.ToUpper(strconv.FormatInt(int64(offset), 16))
+	return strings.Repeat("0", max(0, 8-len(text))) + text
+}
+
+// hex_cursor_position is where the cursor goes on screen
+func hex_cursor_position() (int, int) {
+	row := hexCursor/HEX_BYTES_PER_ROW - hexOffset
+	i := hexCursor % HEX_BYTES_PER_ROW
+	if hexPane == HEX_PANE_ASCII {
+		return HEX_COL_ASCII + i, row
+	}
+	return hex_byte_col(i) + hexNibble, row
+}
@@ -0,0 +1,182 @@
+package main
+
+import "strings"
+
+// How many lines to look at when guessing a file's indentation
+const INDENT_DETECT_LINES = 1000
+
+// indent_unit is the text for one level of indentation
+func indent_unit() []rune {
+	if !indentation.expandTab {
+		return []rune{'\t'}
+	}
+	return []rune(strings.Repeat(" ", indentation.width))
+}
+
+// detect_indent_style guesses whether lines are indented with tabs or spaces,
+// and how many spaces make up a level, falling back to the config defaults
+func detect_indent_style(lines [][]rune) indentStyle {
+	style := indentStyle{expandTab: EXPAND_TAB, width: INDENT_WIDTH}
+
+	tabLines, spaceLines := 0, 0
+	widthVotes := map[int]int{}
+	prevSpaces := 0
+
+	for i, line := range lines {
+		if i >= INDENT_DETECT_LINES {
+			break
+		}
+		if is_indentation(line) {
+			continue
+		}
+		if line[0] == '\t' {
+			tabLines++
+			prevSpaces = 0
+			continue
+		}
+
+		spaces := 0
+		for spaces < len(line) && line[spaces] == ' ' {
+			spaces++
+		}
+		// A single space is usually alignment (e.g. " * " in comments), not indentation
+		if spaces > 1 {
+			spaceLines++
+		}
+
+		// Vote on the change in indentation from the last line
+		delta := spaces - prevSpaces
+		if delta < 0 {
+			delta = -delta
+		}
+		if delta >= 2 && delta <= 8 {
+			widthVotes[delta]++
+		}
+		prevSpaces = spaces
+	}
+
+	if tabLines == 0 && spaceLines == 0 {
+		return style
+	}
+	if tabLines > spaceLines {
+		style.expandTab = false
+		style.width = TAB_WIDTH
+		return style
+	}
+
+	style.expandTab = true
+	bestVotes := 0
+	for width := 2; width <= 8; width++ {
+		if widthVotes[width] > bestVotes {
+			style.width = width
+			bestVotes = widthVotes[width]
+		}
+	}
+	return style
+}
+
+// is_indentation reports whether line is only spaces and tabs
+func is_indentation(line []rune) bool {
+	for _, ch := range line {
+		if ch != ' ' && ch != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// ---------- Editing ----------
+
+func insert_indent() {
+	// Spaces fill up to the next indent stop, so tabbing
+	// after some text still lines up with the levels above
+	if !indentation.expandTab {
+		insert_runes([]rune{'\t'})
+		return
+	}
+	cursorCol := visual_col(textBuffer[currentRow], currentCol)
+	spaces := indentation.width - cursorCol%indentation.width
+	insert_runes([]rune(strings.Repeat(" ", spaces)))
+}
+
+func insert_runes(runes []rune) {
+	line := textBuffer[currentRow]
+	newLine := make([]rune, len(line)+len(runes))
+	copy(newLine[:currentCol], line[:currentCol])
+	copy(newLine[currentCol:currentCol+len(runes)], runes)
+	copy(newLine[currentCol+len(runes):], line[currentCol:])
+
+	textBuffer[currentRow] = newLine
+	currentCol += len(runes)
+	mark_line_dirty(currentRow)
+}
+
+// backspace_width is how many runes a backspace removes:
+// a whole level of space indentation, or a single rune
+func backspace_width() int {
+	line := textBuffer[currentRow]
+	if !indentation.expandTab || currentCol == 0 || line[currentCol-1] != ' ' {
+		return 1
+	}
+	if !is_indentation(line[:currentCol]) {
+		return 1
+	}
+
+	cursorCol := visual_col(line, currentCol)
+	stop := (cursorCol - 1) / indentation.width * indentation.width
+	count := 0
+	for currentCol-count > 0 && line[currentCol-count-1] == ' ' && cursorCol-count > stop {
+		count++
+	}
+	return count
+}
+
+// ---------- Indenting Lines and Blocks ----------
+
+func indent_range(r textRange) {
+	unit := indent_unit()
+	for row := r.start.row; row <= r.end.row; row++ {
+		line := textBuffer[row]
+		if len(line) == 0 {
+			continue
+		}
+		newLine := make([]rune, len(unit)+len(line))
+		copy(newLine, unit)
+		copy(newLine[len(unit):], line)
+		textBuffer[row] = newLine
+		mark_line_dirty(row)
+	}
+	move_to_first_non_blank(r.start.row)
+}
+
+func outdent_range(r textRange) {
+	for row := r.start.row; row <= r.end.row; row++ {
+		line := textBuffer[row]
+
+		// Remove one tab, or up to a level of spaces
+		remove := 0
+		if len(line) > 0 && line[0] == '\t' {
+			remove = 1
+		} else {
+			for remove < len(line) && remove < indentation.width && line[remove] == ' ' {
+				remove++
+			}
+		}
+		if remove == 0 {
+			continue
+		}
+		textBuffer[row] = append([]rune{}, line[remove:]...)
+		mark_line_dirty(row)
+	}
+	move_to_first_non_blank(r.start.row)
+}
+
+func indent_block() {
+	left, right := find_current_block(0)
+	indent_range(textRange{start: position{row: left}, end: position{row: right}, linewise: true})
+}
+
+func outdent_block() {
+	left, right := find_current_block(0)
+	outdent_range(textRange{start: position{row: left}, end: position{row: right}, linewise: true})
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Files of LARGE_FILE_SIZE bytes or more aren't read into memory. The first screen
+// is read straight away, then a background goroutine finds where every line starts.
+// Rows stay nil until they come near the screen, and are read from the file then.
+// The buffer is read-only, so nothing ever has to write the unread parts back.
+
+const (
+	// Read before anything is drawn, so the first screen shows immediately
+	LARGE_FILE_FIRST_READ = 64 * 1024
+
+	// How much the indexer reads at a time
+	LARGE_FILE_CHUNK = 1024 * 1024
+
+	// How often the indexer has the editor pick up new lines
+	LARGE_FILE_WAKE_INTERVAL = 100 * time.Millisecond
+
+	// Rows kept loaded before ones away from the screen are dropped again
+	LARGE_FILE_CACHE_ROWS = 10000
+
+	// Longer lines are cut short, since they're only ever shown
+	LARGE_FILE_MAX_LINE = 64 * 1024
+)
+
+var errLargeFile = errors.New("large files are opened read-only")
+
+// largeIndexer is shared between the editor and the goroutine indexing one file
+type largeIndexer struct {
+	mutex   sync.Mutex
+	offsets []int64 // starts of lines found since last collected
+	end     int64   // where indexing stopped, once done
+	done    bool
+	err     error
+
+	task *task
+}
+
+var (
+	largeFile     bool
+	largeHandle   *os.File
+	largeIndex    *largeIndexer
+	largeIndexing bool
+
+	// Where each row starts. Once indexed, the last entry is where the file
+	// ends. While indexing, the last row is provisional: it runs from the last
+	// entry to the end of the file, and is replaced as more lines are found.
+	largeOffsets []int64
+
+	// Rows that have been read, oldest first
+	largeLoaded []int
+)
+
+// open_large_file takes over file, and shows as much of it as fits in the first read
+func open_large_file(file *os.File, info os.FileInfo) {
+	close_hex()
+	largeFile = true
+	largeHandle = file
+	largeLoaded = largeLoaded[:0]
+	textBuffer = append(textBuffer[:0], nil)
+	diskFile = diskState{exists: true, size: info.Size(), modTime: info.ModTime()}
+	indentation = indentStyle{expandTab: EXPAND_TAB, width: INDENT_WIDTH}
+
+	fileFormat = new_file_format()
+	first := make([]byte, LARGE_FILE_FIRST_READ)
+	n, _ := file.ReadAt(first, 0)
+	first = first[:n]
+	start := int64(0)
+	if bytes.HasPrefix(first, utf8BOM) {
+		fileFormat.bom = true
+		start = int64(len(utf8BOM))
+	}
+	if end := bytes.IndexByte(first, '\n'); end > 0 && first[end-1] == '\r' {
+		fileFormat.lineEnding = "\r\n"
+	}
+
+	largeOffsets = append(largeOffsets[:0], start)
+	add_large_offsets(line_starts(first[start:], start))
+	start_large_indexer(largeOffsets[len(largeOffsets)-1])
+}
+
+func close_large_file() {
+	if !largeFile {
+		return
+	}
+	stop_large_indexer()
+	largeHandle.Close()
+	largeHandle = nil
+	largeFile = false
+	largeOffsets = nil
+	largeLoaded = nil
+	mark_viewport_dirty()
+}
+
+// line_starts is where the lines after each '\n' in data start, offset by base
+func line_starts(data []byte, base int64) []int64 {
+	var starts []int64
+	for i := 0; ; {
+		end := bytes.IndexByte(data[i:], '\n')
+		if end < 0 {
+			return starts
+		}
+		i += end + 1
+		starts = append(starts, base+int64(i))
+	}
+}
+
+// add_large_offsets adds a row for each complete line found,
+// before the provisional last row, which is read again
+func add_large_offsets(starts []int64) {
+	if len(starts) == 0 {
+		return
+	}
+	unload_large_row(len(textBuffer) - 1)
+	largeOffsets = append(largeOffsets, starts...)
+	textBuffer = append(textBuffer, make([][]rune, len(starts))...)
+	sync_dirty_rows()
+	mark_viewport_dirty()
+}
+
+// large_row_end is where a row ends, the end of the file for the provisional row
+func large_row_end(row int) int64 {
+	if row+1 < len(largeOffsets) {
+		return largeOffsets[row+1]
+	}
+	return diskFile.size
+}
+
+// unload_large_row drops a row, so it's read again when needed
+func unload_large_row(row int) {
+	textBuffer[row] = nil
+	largeLoaded = slices.DeleteFunc(largeLoaded, func(loaded int) bool { return loaded == row })
+	sync_dirty_rows()
+	dirtyRows[row] = true
+}
+
+// ---------- Indexing ----------
+
+func start_large_indexer(from int64) {
+	index := &largeIndexer{}
+	file := largeHandle
+	index.task = start_task(func(t *task) {
+		index_large_file(t, file, from, index)
+	})
+	largeIndex = index
+	largeIndexing = true
+}
+
+func stop_large_indexer() {
+	if largeIndex != nil {
+		largeIndex.task.cancel()
+		largeIndex = nil
+	}
+	largeIndexing = false
+}
+
+// index_large_file runs in the background, reading from offset to the end
+// of the file and handing over where the lines start as it goes
+func index_large_file(t *task, file *os.File, offset int64, index *largeIndexer) {
+	chunk := make([]byte, LARGE_FILE_CHUNK)
+	lastWake := time.Now()
+	for !t.cancelled() {
+
+		n, err := file.ReadAt(chunk, offset)
+		starts := line_starts(chunk[:n], offset)
+		offset += int64(n)
+
+		index.mutex.Lock()
+		index.offsets = append(index.offsets, starts...)
+		if err != nil {
+			index.done = true
+			index.end = offset
+			if err != io.EOF {
+				index.err = err
+			}
+		}
+		index.mutex.Unlock()
+
+		// The lines are collected by whichever buffer is current,
+		// once this one is again if it's been switched away from
+		if err != nil || time.Since(lastWake) >= LARGE_FILE_WAKE_INTERVAL {
+			t.post(poll_large_file)
+			lastWake = time.Now()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// poll_large_file collects what the indexer has found since last time
+func poll_large_file() {
+	if !largeIndexing {
+		return
+	}
+	index := largeIndex
+	index.mutex.Lock()
+	starts := index.offsets
+	index.offsets = nil
+	done, end, err := index.done, index.end, index.err
+	index.mutex.Unlock()
+
+	add_large_offsets(starts)
+	if !done {
+		return
+	}
+	largeIndexing = false
+	largeIndex = nil
+	if err != nil {
+		show_error("reading " + filename + fileExtension + ": " + err.Error())
+	}
+
+	// The provisional row is the last line, unless the file ended with a newline
+	last := len(textBuffer) - 1
+	fileFormat.finalNewline = largeOffsets[last] == end
+	if fileFormat.finalNewline && last > 0 {
+		textBuffer = textBuffer[:last]
+		largeLoaded = slices.DeleteFunc(largeLoaded, func(loaded int) bool { return loaded == last })
+		sync_dirty_rows()
+		currentRow = min(currentRow, last-1)
+		mark_viewport_dirty()
+	} else {
+		unload_large_row(last)
+		largeOffsets = append(largeOffsets, end)
+	}
+}
+
+// grow_large_file picks up lines added to the end of the file, like a log
+// being written to, without reading it all again. It's false if the file
+// changed some other way.
+func grow_large_file(state diskState) bool {
+	if !state.exists || state.size < diskFile.size {
+		return false
+	}
+	if largeIndexing {
+		// Wait for the indexer, which may read the new lines itself
+		return true
+	}
+
+	// The unfinished last line becomes provisional again, with whatever was
+	// added to it, or else a provisional row starts after the last line
+	diskFile = state
+	if fileFormat.finalNewline {
+		textBuffer = append(textBuffer, nil)
+	} else {
+		largeOffsets = largeOffsets[:len(largeOffsets)-1]
+	}
+	unload_large_row(len(textBuffer) - 1)
+	mark_viewport_dirty()
+	start_large_indexer(largeOffsets[len(largeOffsets)-1])
+	return true
+}
+
+// ---------- Loading Rows ----------
+
+// load_large_rows reads the rows from first to last that aren't loaded yet
+func load_large_rows(first int, last int) {
+	if !largeFile {
+		return
+	}
+	first = max(first, 0)
+	last = min(last, len(textBuffer)-1)
+
+	var data []byte
+	for row := first; row <= last; row++ {
+		if textBuffer[row] != nil {
+			continue
+		}
+		start, end := largeOffsets[row], large_row_end(row)
+		if end-start > LARGE_FILE_MAX_LINE {
+			end = start + LARGE_FILE_MAX_LINE
+		}
+		if cap(data) < int(end-start) {
+			data = make([]byte, end-start)
+		}
+		data = data[:end-start]
+		n, err := largeHandle.ReadAt(data, start)
+		if err != nil && err != io.EOF {
+			show_error("reading " + filename + fileExtension + ": " + err.Error())
+			return
+		}
+		line := bytes.TrimSuffix(data[:n], []byte("\n"))
+		if fileFormat.lineEnding == "\r\n" {
+			line = bytes.TrimSuffix(line, []byte("\r"))
+		}
+
+		// Loaded rows are never nil, even when empty
+		textBuffer[row] = appendLineRunes(make([]rune, 0, len(line)), string(line))
+		largeLoaded = append(largeLoaded, row)
+		dirtyRows[row] = true
+	}
+}
+
+// evict_large_rows drops the oldest loaded rows, other than first to last
+// and the cursor's, once there are too many
+func evict_large_rows(first int, last int) {
+	if len(largeLoaded) <= LARGE_FILE_CACHE_ROWS {
+		return
+	}
+	kept := largeLoaded[:0]
+	for i, row := range largeLoaded {
+		keep := row >= first && row <= last || row == currentRow
+		if i < len(largeLoaded)-LARGE_FILE_CACHE_ROWS/2 && !keep && row < len(textBuffer) {
+			textBuffer[row] = nil
+			continue
+		}
+		kept = append(kept, row)
+	}
+	largeLoaded = kept
+}
+
+// load_large_view loads the rows on screen, a screen either side of them,
+// and the cursor's row, so moving around sees real lines
+func load_large_view() {
+	if !largeFile {
+		return
+	}
+	first, last := offsetRow-ROWS, offsetRow+2*ROWS
+	load_large_rows(first, last)
+	load_large_rows(currentRow, currentRow)
+	evict_large_rows(first, last)
+}
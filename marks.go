@@ -0,0 +1,204 @@
+package main
+
+// Marks, the jump list and the change list all hold buffer positions
+// that follow their text as lines are inserted and deleted around them.
+
+const (
+	MAX_JUMP_LIST   = 100
+	MAX_CHANGE_LIST = 100
+)
+
+var (
+	marks = map[rune]position{}
+
+	jumpList  []position
+	jumpIndex int
+
+	changeList  []position
+	changeIndex int
+
+	// The command key waiting for a mark name
+	markPending rune
+)
+
+// ---------- Marks ----------
+
+func start_mark(command rune) {
+	markPending = command
+}
+
+func handle_mark_key(name rune) {
+	command := markPending
+	markPending = 0
+	if name == 0 {
+		return
+	}
+
+	switch command {
+	case SET_MARK_KEY:
+		marks[name] = position{row: currentRow, col: currentCol}
+
+	case GOTO_MARK_LINE_KEY, GOTO_MARK_KEY:
+		pos, ok := marks[name]
+		if !ok {
+			return
+		}
+		pos = clamp_position(pos)
+		record_jump()
+		currentRow = pos.row
+		currentCol = pos.col
+		if command == GOTO_MARK_LINE_KEY {
+			move_to_first_non_blank(pos.row)
+		}
+	}
+}
+
+// ---------- Jump List ----------
+
+// record_jump remembers the cursor before a large move
+func record_jump() {
+	pos := position{row: currentRow, col: currentCol}
+
+	// A new jump drops anything ahead of the current place in the list,
+	// along with any older entry for the same line
+	jumpList = jumpList[:jumpIndex]
+	for i := 0; i < len(jumpList); i++ {
+		if jumpList[i].row == pos.row {
+			jumpList = append(jumpList[:i], jumpList[i+1:]...)
+			i--
+		}
+	}
+	jumpList = append(jumpList, pos)
+	if len(jumpList) > MAX_JUMP_LIST {
+		jumpList = jumpList[len(jumpList)-MAX_JUMP_LIST:]
+	}
+	jumpIndex = len(jumpList)
+}
+
+func jump_back() {
+	if jumpIndex == 0 {
+		return
+	}
+	if jumpIndex == len(jumpList) {
+		// Remember where we came from, so jump_forward can return here
+		record_jump()
+		jumpIndex = len(jumpList) - 1
+	}
+	jumpIndex--
+	go_to_position(jumpList[jumpIndex])
+}
+
+func jump_forward() {
+	if jumpIndex >= len(jumpList)-1 {
+		return
+	}
+	jumpIndex++
+	go_to_position(jumpList[jumpIndex])
+}
+
+// ---------- Change List ----------
+
+// record_change remembers where an edit happened,
+// with edits along the same line sharing one entry
+func record_change(row int) {
+	pos := position{row: row, col: 0}
+	if row == currentRow {
+		pos.col = currentCol
+	}
+
+	if len(changeList) > 0 && changeList[len(changeList)-1].row == row {
+		changeList[len(changeList)-1] = pos
+	} else {
+		changeList = append(changeList, pos)
+		if len(changeList) > MAX_CHANGE_LIST {
+			changeList = changeList[len(changeList)-MAX_CHANGE_LIST:]
+		}
+	}
+	changeIndex = len(changeList)
+}
+
+func change_back() {
+	if changeIndex == 0 {
+		return
+	}
+	changeIndex--
+	go_to_position(changeList[changeIndex])
+}
+
+func change_forward() {
+	if changeIndex >= len(changeList)-1 {
+		return
+	}
+	changeIndex++
+	go_to_position(changeList[changeIndex])
+}
+
+func go_to_position(pos position) {
+	pos = clamp_position(pos)
+	currentRow = pos.row
+	currentCol = pos.col
+}
+
+// ---------- Position Tracking ----------
+
+func for_each_tracked_position(update func(pos position) (position, bool)) {
+	for name, pos := range marks {
+		if newPos, keep := update(pos); keep {
+			marks[name] = newPos
+		} else {
+			delete(marks, name)
+		}
+	}
+	// Jump and change list entries are never dropped, only moved
+	for i := range jumpList {
+		jumpList[i], _ = update(jumpList[i])
+	}
+	for i := range changeList {
+		changeList[i], _ = update(changeList[i])
+	}
+}
+
+// shift_tracked_positions moves everything from `from` onwards
+// by rowDelta rows, and the rest of from's line by colDelta columns too.
+// Used when a line is split or joined, or rows are inserted.
+func shift_tracked_positions(from position, rowDelta int, colDelta int) {
+	for_each_tracked_position(func(pos position) (position, bool) {
+		if pos.row == from.row && pos.col >= from.col {
+			return position{row: pos.row + rowDelta, col: pos.col + colDelta}, true
+		}
+		if pos.row > from.row {
+			return position{row: pos.row + rowDelta, col: pos.col}, true
+		}
+		return pos, true
+	})
+}
+
+// delete_tracked_rows forgets marks on rows first to last as they are deleted
+func delete_tracked_rows(first int, last int) {
+	count := last - first + 1
+	for_each_tracked_position(func(pos position) (position, bool) {
+		if pos.row > last {
+			return position{row: pos.row - count, col: pos.col}, true
+		}
+		if pos.row >= first {
+			return position{row: first, col: 0}, false
+		}
+		return pos, true
+	})
+}
+
+// delete_tracked_range forgets marks inside [start, end) as it is deleted
+func delete_tracked_range(start position, end position) {
+	for_each_tracked_position(func(pos position) (position, bool) {
+		if position_before(pos, start) {
+			return pos, true
+		}
+		if position_before(pos, end) {
+			return start, false
+		}
+		if pos.row == end.row {
+			return position{row: start.row, col: start.col + pos.col - end.col}, true
+		}
+		return position{row: pos.row - (end.row - start.row), col: pos.col}, true
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Messages show on the line under the status bar until they time out,
+// and are kept in a history that `:messages` opens in the pager.
+
+const MAX_MESSAGE_HISTORY = 200
+
+const (
+	MESSAGE_INFO = iota
+	MESSAGE_WARNING
+	MESSAGE_ERROR
+)
+
+type message struct {
+	text  string
+	level int
+	shown time.Time
+}
+
+var (
+	messageHistory []message
+
+	// The message on screen, if any
+	currentMessage  message
+	messageVisible  bool
+	messageDeadline time.Time
+)
+
+func show_info(text string) {
+	show_message(MESSAGE_INFO, text)
+}
+
+func show_warning(text string) {
+	show_message(MESSAGE_WARNING, text)
+}
+
+func show_error(text string) {
+	show_message(MESSAGE_ERROR, text)
+}
+
+func show_message(level int, text string) {
+	currentMessage = message{text: text, level: level, shown: time.Now()}
+	messageVisible = true
+
+	messageHistory = append(messageHistory, currentMessage)
+	if len(messageHistory) > MAX_MESSAGE_HISTORY {
+		messageHistory = messageHistory[len(messageHistory)-MAX_MESSAGE_HISTORY:]
+	}
+
+	timeout := MESSAGE_TIMEOUT
+	if level == MESSAGE_ERROR {
+		timeout = ERROR_MESSAGE_TIMEOUT
+	}
+	messageDeadline = currentMessage.shown.Add(timeout)
+
+	after(timeout, expire_message)
+}
+
+func expire_message() {
+	if messageVisible && !time.Now().Before(messageDeadline) {
+		messageVisible = false
+	}
+}
+
+func message_color(level int) termbox.Attribute {
+	switch level {
+	case MESSAGE_WARNING:
+		return termbox.ColorYellow
+	case MESSAGE_ERROR:
+		return termbox.ColorRed
+	}
+	return termbox.ColorDefault
+}
+
+func message_level_name(level int) string {
+	switch level {
+	case MESSAGE_WARNING:
+		return "warning"
+	case MESSAGE_ERROR:
+		return "error"
+	}
+	return "info"
+}
+
+// display_message_line draws the command line while it's open,
+// or else the current message
+func display_message_line() {
+	row := ROWS + 1
+	clear_screen_row(row)
+
+	switch {
+	case commandActive:
+		print_message(0, row, termbox.ColorDefault, termbox.ColorDefault, ":"+string(commandInput))
+	case pagerActive:
+		print_message(0, row, termbox.ColorDefault, termbox.ColorDefault, pager_status())
+	case promptActive:
+		print_message(0, row, termbox.ColorYellow, termbox.ColorDefault, prompt_status())
+	case messageVisible:
+		print_message(0, row, message_color(currentMessage.level), termbox.ColorDefault, currentMessage.text)
+	}
+}
+
+// ---------- History ----------
+
+func open_message_history() {
+	lines := make([]pagerLine, len(messageHistory))
+	for i, msg := range messageHistory {
+		lines[i] = pagerLine{
+			text: msg.shown.Format("15:04:05") + " " + message_level_name(msg.level) + ": " + msg.text,
+			fg:   message_color(msg.level),
+		}
+	}
+	open_pager("messages", lines)
+
+	// Start at the newest messages
+	pager_scroll(len(lines))
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// motion moves from a position to a new one, `count` times.
+// A count of 0 means no count was typed.
+// Every motion can be used on its own in [VIEW] mode, or after an operator.
+type motion struct {
+	move      func(from position, count int) position
+	linewise  bool
+	inclusive bool
+	jump      bool // recorded in the jump list
+}
+
+// Motions bound to printable keys
+var motions = map[rune]motion{
+	CURSOR_LEFT:  {move: motion_left},
+	CURSOR_DOWN:  {move: motion_down, linewise: true},
+	CURSOR_UP:    {move: motion_up, linewise: true},
+	CURSOR_RIGHT: {move: motion_right},
+
+	JUMP_UP:   {move: motion_jump_up, linewise: true, jump: true},
+	JUMP_DOWN: {move: motion_jump_down, linewise: true, jump: true},
+
+	NEXT_WORD_START:     {move: motion_next_word_start},
+	PREV_WORD_START:     {move: motion_prev_word_start},
+	NEXT_WORD_END:       {move: motion_next_word_end, inclusive: true},
+	PREV_WORD_END:       {move: motion_prev_word_end, inclusive: true},
+	NEXT_BIG_WORD_START: {move: motion_next_big_word_start},
+	PREV_BIG_WORD_START: {move: motion_prev_big_word_start},
+	NEXT_BIG_WORD_END:   {move: motion_next_big_word_end, inclusive: true},
+	PREV_BIG_WORD_END:   {move: motion_prev_big_word_end, inclusive: true},
+
+	PREV_SENTENCE:  {move: motion_prev_sentence, jump: true},
+	NEXT_SENTENCE:  {move: motion_next_sentence, jump: true},
+	PREV_PARAGRAPH: {move: motion_prev_paragraph, jump: true},
+	NEXT_PARAGRAPH: {move: motion_next_paragraph, jump: true},
+
+	LINE_START_MOTION: {move: motion_line_start},
+	LINE_END_MOTION:   {move: motion_line_end},
+
+	BLOCK_START_MOTION: {move: motion_block_start, linewise: true, jump: true},
+	BLOCK_END_MOTION:   {move: motion_block_end, linewise: true, jump: true},
+}
+
+// Motions bound to special keys
+var keyMotions = map[termbox.Key]motion{
+	START_OF_LINE: {move: motion_line_start},
+	END_OF_LINE:   {move: motion_line_end},
+}
+
+func lookup_motion(event termbox.Event) (motion, bool) {
+	if event.Ch != 0 {
+		m, ok := motions[event.Ch]
+		return m, ok
+	}
+	m, ok := keyMotions[event.Key]
+	return m, ok
+}
+
+func move_cursor(m motion, count int) {
+	if m.jump {
+		record_jump()
+	}
+	target := clamp_position(m.move(clamp_position(position{row: currentRow, col: currentCol}), count))
+	currentRow = target.row
+	currentCol = target.col
+}
+
+// motion_range is the text covered by moving from the cursor with m
+func motion_range(m motion, count int) textRange {
+	from := clamp_position(position{row: currentRow, col: currentCol})
+	to := clamp_position(m.move(from, count))
+
+	start, end := from, to
+	if position_before(to, from) {
+		start, end = to, from
+	}
+	if m.linewise {
+		return textRange{start: start, end: end, linewise: true}
+	}
+	if m.inclusive && end.col < len(textBuffer[end.row]) {
+		end.col++
+	}
+	// An exclusive motion that lands at the start of a later line
+	// stops at the end of the line before it, so the newline survives
+	if !m.inclusive && end.col == 0 && end.row > start.row {
+		end.row--
+		end.col = len(textBuffer[end.row])
+	}
+	return textRange{start: start, end: end}
+}
+
+func at_least_one(count int) int {
+	if count < 1 {
+		return 1
+	}
+	return count
+}
+
+// ---------- Character and Line Motions ----------
+
+func motion_left(from position, count int) position {
+	for i := 0; i < at_least_one(count); i++ {
+		if from.col != 0 {
+			from.col--
+		} else if from.row > 0 {
+			from.row--
+			from.col = len(textBuffer[from.row])
+		}
+	}
+	return from
+}
+
+func motion_right(from position, count int) position {
+	for i := 0; i < at_least_one(count); i++ {
+		if from.col < len(textBuffer[from.row]) {
+			from.col++
+		} else if from.row < len(textBuffer)-1 {
+			from.row++
+			from.col = 0
+		}
+	}
+	return from
+}
+
+// Moving between lines keeps the cursor in the same screen column
+func motion_up(from position, count int) position {
+	return move_rows(from, -at_least_one(count))
+}
+
+func motion_down(from position, count int) position {
+	return move_rows(from, at_least_one(count))
+}
+
+func move_rows(from position, delta int) position {
+	row := from.row + delta
+	if row < 0 {
+		row = 0
+	} else if row >= len(textBuffer) {
+		row = len(textBuffer) - 1
+	}
+	cursorCol := visual_col(textBuffer[from.row], from.col)
+	return position{row: row, col: rune_col_at_visual(textBuffer[row], cursorCol)}
+}
+
+// Without a count, jumps go to the top or bottom of the file
+func motion_jump_up(from position, count int) position {
+	if count == 0 {
+		return position{row: 0, col: 0}
+	}
+	return position{row: from.row - count, col: 0}
+}
+
+func motion_jump_down(from position, count int) position {
+	if count == 0 {
+		return position{row: len(textBuffer) - 1, col: 0}
+	}
+	return position{row: from.row + count, col: 0}
+}
+
+func motion_line_start(from position, count int) position {
+	// first non-whitespace character of the line
+	line := textBuffer[from.row]
+	for i, ch := range line {
+		if ch != ' ' && ch != '\t' {
+			return position{row: from.row, col: i}
+		}
+	}
+	return position{row: from.row, col: len(line)}
+}
+
+func motion_line_end(from position, count int) position {
+	// A count moves to the end of a later line
+	row := from.row + at_least_one(count) - 1
+	if row >= len(textBuffer) {
+		row = len(textBuffer) - 1
+	}
+	return position{row: row, col: len(textBuffer[row])}
+}
+
+// ---------- Block Motions ----------
+
+// Counts pick an outer block, like repeated block copies do
+func motion_block_start(from position, count int) position {
+	left, _ := find_block_around(from.row, at_least_one(count)-1)
+	return position{row: left, col: 0}
+}
+
+func motion_block_end(from position, count int) position {
+	_, right := find_block_around(from.row, at_least_one(count)-1)
+	return position{row: right, col: len(textBuffer[right])}
+}
+
+// ---------- Word Motions ----------
+
+// rune_class splits runes into whitespace (0), word runes (1) and punctuation (2).
+// For WORDs, everything that isn't whitespace is a single class.
+func rune_class(r rune, bigWord bool) int {
+	if r == '\n' || unicode.IsSpace(r) {
+		return 0
+	}
+	if bigWord || is_symbol_rune(r) {
+		return 1
+	}
+	return 2
+}
+
+func is_empty_line_start(pos position) bool {
+	return pos.col == 0 && len(textBuffer[pos.row]) == 0
+}
+
+func next_word_start(pos position, bigWord bool) position {
+	startRow := pos.row
+	class := rune_class(rune_at_position(pos), bigWord)
+	ok := true
+
+	// Leave the current word...
+	for ok && class != 0 && rune_class(rune_at_position(pos), bigWord) == class {
+		pos, ok = next_position(pos)
+	}
+	// ...and the whitespace after it, stopping on empty lines
+	for ok && rune_class(rune_at_position(pos), bigWord) == 0 {
+		if pos.row != startRow && is_empty_line_start(pos) {
+			break
+		}
+		pos, ok = next_position(pos)
+	}
+	return pos
+}
+
+func prev_word_start(pos position, bigWord bool) position {
+	pos, ok := prev_position(pos)
+	for ok && rune_class(rune_at_position(pos), bigWord) == 0 && !is_empty_line_start(pos) {
+		pos, ok = prev_position(pos)
+	}
+	class := rune_class(rune_at_position(pos), bigWord)
+	for class != 0 {
+		prev, ok := prev_position(pos)
+		if !ok || rune_class(rune_at_position(prev), bigWord) != class {
+			break
+		}
+		pos = prev
+	}
+	return pos
+}
+
+func next_word_end(pos position, bigWord bool) position {
+	pos, ok := next_position(pos)
+	for ok && rune_class(rune_at_position(pos), bigWord) == 0 {
+		pos, ok = next_position(pos)
+	}
+	class := rune_class(rune_at_position(pos), bigWord)
+	for class != 0 {
+		next, ok := next_position(pos)
+		if !ok || rune_class(rune_at_position(next), bigWord) != class {
+			break
+		}
+		pos = next
+	}
+	return pos
+}
+
+func prev_word_end(pos position, bigWord bool) position {
+	class := rune_class(rune_at_position(pos), bigWord)
+	ok := true
+
+	// Leave the current word...
+	for ok && class != 0 && rune_class(rune_at_position(pos), bigWord) == class {
+		pos, ok = prev_position(pos)
+	}
+	// ...and the whitespace before it, stopping on empty lines
+	for ok && rune_class(rune_at_position(pos), bigWord) == 0 && !is_empty_line_start(pos) {
+		pos, ok = prev_position(pos)
+	}
+	return pos
+}
+
+func repeat_word_motion(from position, count int, bigWord bool, step func(position, bool) position) position {
+	for i := 0; i < at_least_one(count); i++ {
+		from = step(from, bigWord)
+	}
+	return from
+}
+
+func motion_next_word_start(from position, count int) position {
+	return repeat_word_motion(from, count, false, next_word_start)
+}
+
+func motion_prev_word_start(from position, count int) position {
+	return repeat_word_motion(from, count, false, prev_word_start)
+}
+
+func motion_next_word_end(from position, count int) position {
+	return repeat_word_motion(from, count, false, next_word_end)
+}
+
+func motion_prev_word_end(from position, count int) position {
+	return repeat_word_motion(from, count, false, prev_word_end)
+}
+
+func motion_next_big_word_start(from position, count int) position {
+	return repeat_word_motion(from, count, true, next_word_start)
+}
+
+func motion_prev_big_word_start(from position, count int) position {
+	return repeat_word_motion(from, count, true, prev_word_start)
+}
+
+func motion_next_big_word_end(from position, count int) position {
+	return repeat_word_motion(from, count, true, next_word_end)
+}
+
+func motion_prev_big_word_end(from position, count int) position {
+	return repeat_word_motion(from, count, true, prev_word_end)
+}
+
+// ---------- Sentence and Paragraph Motions ----------
+
+// is_sentence_start reports whether a sentence begins at pos:
+// the first non-blank after a '.', '!' or '?' (and any closing brackets or quotes),
+// or after an empty line. Empty lines are sentences of their own.
+func is_sentence_start(pos position) bool {
+	if is_empty_line_start(pos) {
+		return pos.row == 0 || len(textBuffer[pos.row-1]) != 0
+	}
+	if unicode.IsSpace(rune_at_position(pos)) {
+		return false
+	}
+
+	// Step back over the whitespace before pos
+	prev, ok := prev_position(pos)
+	if !ok {
+		return true
+	}
+	if !unicode.IsSpace(rune_at_position(prev)) {
+		return false
+	}
+	for ok && unicode.IsSpace(rune_at_position(prev)) {
+		if is_empty_line_start(prev) {
+			return true
+		}
+		prev, ok = prev_position(prev)
+	}
+	if !ok {
+		return true
+	}
+
+	// ...then over any closers, to the punctuation ending the last sentence
+	for ok && strings.ContainsRune(")]\"'", rune_at_position(prev)) {
+		prev, ok = prev_position(prev)
+	}
+	return ok && strings.ContainsRune(".!?", rune_at_position(prev))
+}
+
+func motion_next_sentence(from position, count int) position {
+	for i := 0; i < at_least_one(count); i++ {
+		pos, ok := next_position(from)
+		for ok && !is_sentence_start(pos) {
+			pos, ok = next_position(pos)
+		}
+		from = pos
+	}
+	return from
+}
+
+func motion_prev_sentence(from position, count int) position {
+	for i := 0; i < at_least_one(count); i++ {
+		pos, ok := prev_position(from)
+		for ok && !is_sentence_start(pos) {
+			pos, ok = prev_position(pos)
+		}
+		from = pos
+	}
+	return from
+}
+
+// Paragraphs are separated by blank lines, like the paragraph text object
+func motion_next_paragraph(from position, count int) position {
+	row := from.row
+	for i := 0; i < at_least_one(count); i++ {
+		for row < len(textBuffer)-1 && is_blank_line(row) {
+			row++
+		}
+		for row < len(textBuffer)-1 && !is_blank_line(row) {
+			row++
+		}
+	}
+	if row == len(textBuffer)-1 && !is_blank_line(row) {
+		return position{row: row, col: len(textBuffer[row])}
+	}
+	return position{row: row, col: 0}
+}
+
+func motion_prev_paragraph(from position, count int) position {
+	row := from.row
+	for i := 0; i < at_least_one(count); i++ {
+		for row > 0 && is_blank_line(row) {
+			row--
+		}
+		for row > 0 && !is_blank_line(row) {
+			row--
+		}
+	}
+	return position{row: row, col: 0}
+}
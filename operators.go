@@ -0,0 +1,169 @@
+package main
+
+import (
+	"unicode"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Operators wait for a motion or a text object to act on:
+//   <count><operator><count><motion>
+//   <operator><scope><object>
+//   <count><operator><operator>   (whole lines)
+
+// ---------- Counts ----------
+
+func start_count() {
+	countPending = true
+	pendingCount = 0
+}
+
+func handle_count_digit(ch rune) bool {
+	if ch < '0' || ch > '9' {
+		// The count carries over to the command being typed,
+		// special keys don't take counts
+		countPending = false
+		if ch == 0 {
+			pendingCount = 0
+		}
+		return false
+	}
+	pendingCount = pendingCount*10 + int(ch-'0')
+	return true
+}
+
+// take_count returns the typed count (0 if there was none) and clears it
+func take_count() int {
+	count := pendingCount
+	countPending = false
+	pendingCount = 0
+	return count
+}
+
+// combine_counts multiplies the counts before and after an operator
+func combine_counts(operatorCount int, motionCount int) int {
+	if operatorCount == 0 {
+		return motionCount
+	}
+	if motionCount == 0 {
+		return operatorCount
+	}
+	return operatorCount * motionCount
+}
+
+// ---------- Operator Pending ----------
+
+func start_operator(operator rune, count int) {
+	operatorPending = true
+	pendingOperator = operator
+	pendingScope = 0
+	operatorCount = count
+	motionCount = 0
+}
+
+func reset_operator_state() {
+	operatorPending = false
+	pendingOperator = 0
+	pendingScope = 0
+	operatorCount = 0
+	motionCount = 0
+}
+
+func handle_operator_key(event termbox.Event) {
+	operator := pendingOperator
+	count := combine_counts(operatorCount, motionCount)
+
+	// <operator><scope><object>
+	if pendingScope != 0 {
+		r, found := find_text_object(pendingScope, event.Ch)
+		reset_operator_state()
+		if found {
+			apply_operator(operator, r)
+		}
+		return
+	}
+
+	switch {
+	case event.Ch >= '0' && event.Ch <= '9':
+		motionCount = motionCount*10 + int(event.Ch-'0')
+		return
+
+	case event.Ch == INSIDE_OBJECT || event.Ch == AROUND_OBJECT:
+		pendingScope = event.Ch
+		return
+
+	case event.Ch == operator:
+		// Doubling the operator works on whole lines, starting from the cursor
+		reset_operator_state()
+		lastRow := currentRow + at_least_one(count) - 1
+		if lastRow >= len(textBuffer) {
+			lastRow = len(textBuffer) - 1
+		}
+		apply_operator(operator, textRange{
+			start:    position{row: currentRow},
+			end:      position{row: lastRow},
+			linewise: true,
+		})
+		return
+	}
+
+	// <operator><motion>, anything else cancels the operator
+	reset_operator_state()
+	if m, ok := lookup_motion(event); ok {
+		apply_operator(operator, motion_range(m, count))
+	}
+}
+
+func apply_operator(operator rune, r textRange) {
+	switch operator {
+	case COPY_OPERATOR_KEY:
+		copy_range(r)
+		currentRow = r.start.row
+		currentCol = r.start.col
+	case CUT_OPERATOR_KEY:
+		copy_range(r)
+		delete_range(r)
+	case CHANGE_OPERATOR_KEY:
+		change_range(r)
+	case DEL_OPERATOR_KEY:
+		delete_range(r)
+	case INDENT_OPERATOR_KEY:
+		indent_range(r)
+	case OUTDENT_OPERATOR_KEY:
+		outdent_range(r)
+	case CASE_OPERATOR_KEY:
+		toggle_case_range(r)
+	}
+}
+
+// ---------- Operator Actions ----------
+
+func toggle_case_range(r textRange) {
+	for row := r.start.row; row <= r.end.row; row++ {
+		line := textBuffer[row]
+		left, right := 0, len(line)
+		if !r.linewise {
+			if row == r.start.row {
+				left = r.start.col
+			}
+			if row == r.end.row {
+				right = r.end.col
+			}
+		}
+		for col := left; col < right; col++ {
+			if unicode.IsUpper(line[col]) {
+				line[col] = unicode.ToLower(line[col])
+			} else {
+				line[col] = unicode.ToUpper(line[col])
+			}
+		}
+		mark_line_dirty(row)
+	}
+	currentRow = r.start.row
+	currentCol = r.start.col
+}
+
+func move_to_first_non_blank(row int) {
+	currentRow = row
+	currentCol = motion_line_start(position{row: row}, 0).col
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strconv"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// The pager shows read-only text over the buffer,
+// such as the message history, until it's closed
+
+type pagerLine struct {
+	text string
+	fg   termbox.Attribute
+}
+
+var (
+	pagerActive bool
+	pagerTitle  string
+	pagerLines  []pagerLine
+	pagerOffset int
+
+	// Runs once the pager is closed, e.g. to ask a question again
+	pagerOnClose func()
+)
+
+func open_pager(title string, lines []pagerLine) {
+	pagerActive = true
+	pagerTitle = title
+	pagerLines = lines
+	pagerOffset = 0
+	pagerOnClose = nil
+}
+
+func close_pager() {
+	pagerActive = false
+	pagerLines = nil
+	mark_viewport_dirty()
+
+	if onClose := pagerOnClose; onClose != nil {
+		pagerOnClose = nil
+		onClose()
+	}
+}
+
+func pager_scroll(delta int) {
+	pagerOffset += delta
+	if pagerOffset > len(pagerLines)-ROWS {
+		pagerOffset = len(pagerLines) - ROWS
+	}
+	if pagerOffset < 0 {
+		pagerOffset = 0
+	}
+}
+
+func handle_pager_key(event termbox.Event) {
+	switch {
+	case event.Ch == CURSOR_DOWN || event.Key == termbox.KeyArrowDown:
+		pager_scroll(1)
+	case event.Ch == CURSOR_UP || event.Key == termbox.KeyArrowUp:
+		pager_scroll(-1)
+	case event.Key == PAGE_DOWN:
+		pager_scroll(ROWS)
+	case event.Key == PAGE_UP:
+		pager_scroll(-ROWS)
+	case event.Ch == JUMP_DOWN:
+		pager_scroll(len(pagerLines))
+	case event.Ch == JUMP_UP:
+		pager_scroll(-len(pagerLines))
+	case event.Key == termbox.KeyEsc, event.Key == termbox.KeyEnter, event.Ch == QUIT_NOSAVE:
+		close_pager()
+	}
+}
+
+func display_pager() {
+	for row := 0; row < ROWS; row++ {
+		clear_screen_row(row)
+		index := pagerOffset + row
+		if index < len(pagerLines) {
+			print_message(0, row, pagerLines[index].fg, termbox.ColorDefault, pagerLines[index].text)
+		}
+	}
+}
+
+func pager_status() string {
+	if len(pagerLines) == 0 {
+		return "-- " + pagerTitle + " -- empty (Esc to close)"
+	}
+	last := pagerOffset + ROWS
+	if last > len(pagerLines) {
+		last = len(pagerLines)
+	}
+	return "-- " + pagerTitle + " -- lines " + strconv.Itoa(pagerOffset+1) + "-" + strconv.Itoa(last) +
+		" of " + strconv.Itoa(len(pagerLines)) + " (Esc to close)"
+}
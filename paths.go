@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A buffer's file is kept as the path it was opened with, split into filename
+// and fileExtension. Comparing files goes by their canonical path instead,
+// so "a.txt", "./a.txt" and a symlink to it are all the same file.
+
+const UNNAMED_BUFFER_NAME = "(unnamed)"
+
+var (
+	errNoFileName = errors.New("the buffer has no file name yet")
+	errNoDir      = errors.New("the directory doesn't exist")
+)
+
+// set_file_name splits a path into filename and fileExtension.
+// Only the last part of the path can have an extension,
+// and a dotfile like .bashrc is all name.
+func set_file_name(path string) {
+	fileExtension = filepath.Ext(path)
+	if fileExtension == filepath.Base(path) {
+		fileExtension = ""
+	}
+	filename = strings.TrimSuffix(path, fileExtension)
+}
+
+// display_name is how a buffer's file is shown
+func display_name(filename string, fileExtension string) string {
+	if filename == "" && fileExtension == "" {
+		return UNNAMED_BUFFER_NAME
+	}
+	return filename + fileExtension
+}
+
+// canonical_path is the absolute path of a file with symlinks resolved.
+// For a file that doesn't exist yet, the directory it would be in is resolved.
+func canonical_path(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(dir, filepath.Base(abs))
+	}
+	return abs
+}
+
+// same_file reports whether two paths name the same file,
+// including through hard links
+func same_file(a string, b string) bool {
+	if canonical_path(a) == canonical_path(b) {
+		return true
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// find_buffer is the buffer that has path open, or -1
+func find_buffer(path string) int {
+	for i, b := range buffers {
+		if i == currentBuffer {
+			b.filename, b.fileExtension = filename, fileExtension
+		}
+		if b.filename+b.fileExtension != "" && same_file(path, b.filename+b.fileExtension) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ---------- Saving As ----------
+
+// ask_save_as opens the command line ready for a path to save to
+func ask_save_as() {
+	open_command_line()
+	commandInput = append(commandInput, []rune("saveas ")...)
+}
+
+// save_as gives the buffer a new file and saves it there,
+// asking first before replacing a different file.
+// A read-only buffer can be saved as a copy, which can then be edited.
+func save_as(path string) error {
+	if pipeMode {
+		return errors.New("a pipe is saved to standard output")
+	}
+	if largeFile {
+		return errLargeFile
+	}
+	if !file_writable(path) {
+		return errors.New(path + " can't be written")
+	}
+	if other := find_buffer(path); other >= 0 && other != currentBuffer {
+		return errors.New(path + " is already open in buffer " + strconv.Itoa(other+1))
+	}
+
+	current := filename + fileExtension
+	if _, err := os.Stat(path); err == nil && (current == "" || !same_file(path, current)) {
+		open_prompt(path+" already exists:", []promptChoice{
+			{key: 'o', label: "overwrite", action: func() { rename_and_save(path) }},
+			{key: 'c', label: "cancel", action: func() {}},
+		})
+		return nil
+	}
+	rename_and_save(path)
+	return nil
+}
+
+func rename_and_save(path string) {
+	// The old swap file is for the old name
+	close_buffer_swap()
+	set_file_name(path)
+	readOnly = false
+	keep_buffer()
+	write_file(filename, fileExtension)
+}
+
+// ---------- Missing Directories ----------
+
+func dir_exists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// prompt_create_dir offers to make the missing directories a file is saved in
+func prompt_create_dir(dir string) {
+	open_prompt(dir+" doesn't exist:", []promptChoice{
+		{key: 'c', label: "create it and save", action: func() {
+			if err := os.MkdirAll(dir, 0o777); err != nil {
+				show_error("save failed: " + err.Error())
+				return
+			}
+			write_file(filename, fileExtension)
+		}},
+		{key: 'n', label: "cancel", action: func() {}},
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// With --pipe, the editor works like an interactive filter: the buffer is read
+// from standard input, and is written to standard output when the editor quits,
+// e.g. "goatpad --pipe < in > out". The terminal itself is reached through
+// /dev/tty, which termbox opens whatever stdin and stdout are.
+
+const PIPE_BUFFER_NAME = "(pipe)"
+
+var (
+	pipeMode bool
+
+	// What was last saved, or read if it never was, written to stdout on quit
+	pipeOutput []byte
+
+	// Set when quitting throws away the edit, so nothing is written
+	pipeDiscarded bool
+)
+
+// save_pipe keeps the buffer to write out on quit
+func save_pipe() error {
+	encoded, err := encode_buffer()
+	if err != nil {
+		saveFailed = true
+		show_error("save failed: " + err.Error())
+		return err
+	}
+	pipeOutput = encoded
+	saveFailed = false
+	modified = false
+	show_info("saved, it's written to standard output on quit")
+	return nil
+}
+
+// finish_pipe writes out the saved buffer as the editor exits,
+// and is the exit code. Discarding on the way out writes nothing,
+// and fails, so a pipeline can tell the edit was abandoned.
+func finish_pipe() int {
+	if !pipeMode {
+		return 0
+	}
+	if pipeDiscarded {
+		fmt.Fprintln(os.Stderr, "goatpad: quit discarding changes, nothing written")
+		return 1
+	}
+	if _, err := os.Stdout.Write(pipeOutput); err != nil {
+		fmt.Fprintln(os.Stderr, "goatpad: writing standard output: "+err.Error())
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// A prompt asks a question on the message line, and waits
+// for one of its choice keys before the editor carries on
+
+type promptChoice struct {
+	key    rune
+	label  string
+	action func()
+}
+
+var (
+	promptActive  bool
+	promptText    string
+	promptChoices []promptChoice
+)
+
+func open_prompt(text string, choices []promptChoice) {
+	promptActive = true
+	promptText = text
+	promptChoices = choices
+}
+
+func handle_prompt_key(event termbox.Event) {
+	for _, choice := range promptChoices {
+		if event.Ch == choice.key {
+			promptActive = false
+			promptChoices = nil
+			choice.action()
+			return
+		}
+	}
+}
+
+func prompt_status() string {
+	labels := make([]string, len(promptChoices))
+	for i, choice := range promptChoices {
+		labels[i] = string(choice.key) + "=" + choice.label
+	}
+	return promptText + " [" + strings.Join(labels, ", ") + "]"
+}
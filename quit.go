@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Quitting, and closing a buffer, ask first when there are unsaved changes.
+// FORCE_QUIT (and :quit!) is the way out without asking.
+
+// How many buffer names the quit prompt lists before just counting the rest
+const QUIT_PROMPT_NAMES = 3
+
+// unsaved_buffers is every buffer with changes that aren't saved
+func unsaved_buffers() []int {
+	buffers[currentBuffer] = stash_buffer()
+	var unsaved []int
+	for i, b := range buffers {
+		if b.modified {
+			unsaved = append(unsaved, i)
+		}
+	}
+	return unsaved
+}
+
+// quit_editor exits, unless there are unsaved changes to ask about
+func quit_editor() {
+	unsaved := unsaved_buffers()
+	if len(unsaved) == 0 {
+		exit_editor()
+		return
+	}
+
+	names := make([]string, 0, QUIT_PROMPT_NAMES)
+	for _, i := range unsaved[:min(len(unsaved), QUIT_PROMPT_NAMES)] {
+		names = append(names, display_name(buffers[i].filename, buffers[i].fileExtension))
+	}
+	if len(unsaved) > QUIT_PROMPT_NAMES {
+		names = append(names, strconv.Itoa(len(unsaved)-QUIT_PROMPT_NAMES)+" more")
+	}
+	text := "Unsaved changes in " + strings.Join(names, ", ") + ":"
+	open_prompt(text, []promptChoice{
+		{key: 's', label: "save all and quit", action: save_all_and_quit},
+		{key: 'd', label: "discard and quit", action: discard_and_quit},
+		{key: 'c', label: "cancel", action: func() {}},
+	})
+}
+
+// discard_and_quit exits without asking, throwing away unsaved changes
+func discard_and_quit() {
+	pipeDiscarded = true
+	exit_editor()
+}
+
+// save_and_quit saves the current buffer, then quits as usual
+func save_and_quit() {
+	// Stay open if the save fails, so nothing is lost
+	if write_file(filename, fileExtension) != nil {
+		return
+	}
+	quit_editor()
+}
+
+// save_all_and_quit saves each unsaved buffer in turn, stopping at the first
+// that can't be saved yet, e.g. one that still needs a name
+func save_all_and_quit() {
+	for _, i := range unsaved_buffers() {
+		switch_buffer(i)
+		if write_file(filename, fileExtension) != nil {
+			return
+		}
+	}
+	exit_editor()
+}
+
+// ---------- Closing Buffers ----------
+
+// close_current_buffer closes the buffer, asking first if it has unsaved changes
+func close_current_buffer() {
+	if !modified {
+		remove_current_buffer()
+		return
+	}
+	open_prompt("Unsaved changes in "+display_name(filename, fileExtension)+":", []promptChoice{
+		{key: 's', label: "save and close", action: func() {
+			if write_file(filename, fileExtension) == nil {
+				remove_current_buffer()
+			}
+		}},
+		{key: 'd', label: "discard and close", action: remove_current_buffer},
+		{key: 'c', label: "cancel", action: func() {}},
+	})
+}
+
+// remove_current_buffer closes the buffer without asking.
+// Closing the last one leaves an empty buffer.
+func remove_current_buffer() {
+	close_large_file()
+	close_buffer_swap()
+
+	buffers = append(buffers[:currentBuffer], buffers[currentBuffer+1:]...)
+	if len(buffers) == 0 {
+		buffers = append(buffers, empty_buffer())
+	}
+	currentBuffer = min(currentBuffer, len(buffers)-1)
+	restore_buffer(buffers[currentBuffer])
+	show_buffer()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Bytes that aren't valid UTF-8 are kept in the buffer as the runes U+DC80 to U+DCFF.
+// Decoding valid UTF-8 never gives these (they're surrogate halves),
+// so saving can turn them back into exactly the bytes that were read.
+
+const RAW_BYTE_BASE = 0xDC00
+
+func raw_byte_rune(b byte) rune {
+	return RAW_BYTE_BASE + rune(b)
+}
+
+func is_raw_byte(ch rune) bool {
+	return ch >= RAW_BYTE_BASE+0x80 && ch <= RAW_BYTE_BASE+0xFF
+}
+
+// append_line_bytes encodes a line as UTF-8, with raw bytes as they were
+func append_line_bytes(dst []byte, line []rune) []byte {
+	for _, ch := range line {
+		if is_raw_byte(ch) {
+			dst = append(dst, byte(ch-RAW_BYTE_BASE))
+		} else {
+			dst = utf8.AppendRune(dst, ch)
+		}
+	}
+	return dst
+}
+
+func line_string(line []rune) string {
+	return string(append_line_bytes(nil, line))
+}
+
+// rune_display is how a rune that can't safely be drawn as itself is shown,
+// like "^M" for a carriage return or "<80>" for an invalid byte.
+// It's "" for runes that are drawn as they are.
+func rune_display(ch rune) string {
+	switch {
+	case ch == '\t':
+		return ""
+	case ch < 0x20:
+		return "^" + string(ch+'@')
+	case ch == 0x7F:
+		return "^?"
+	case is_raw_byte(ch):
+		return "<" + hex_byte(byte(ch-RAW_BYTE_BASE)) + ">"
+	case ch >= 0x80 && ch < 0xA0:
+		// C1 control characters, which terminals may act on
+		return "<U+00" + hex_byte(byte(ch)) + ">"
+	}
+	return ""
+}
+
+func hex_byte(b byte) string {
+	text := strings.ToUpper(strconv.FormatUint(uint64(b), 16))
+	if len(text) < 2 {
+		text = "0" + text
+	}
+	return text
+}
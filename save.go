@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Set when the last save failed, until a save succeeds
+var saveFailed bool
+
+// How many temp file names to try before giving up
+const SAVE_TEMP_ATTEMPTS = 100
+
+// write_atomic replaces path with data without ever leaving a half written file:
+// the data goes to a temp file next to the target, is synced to disk,
+// and then renamed over it. On failure the original is left untouched.
+func write_atomic(path string, data []byte) error {
+	// Saving through a symlink replaces the file it points to, not the link
+	target, err := resolve_symlinks(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(target)
+	existing := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if existing && !info.Mode().IsRegular() {
+		return errors.New(target + " is not a regular file")
+	}
+
+	// New files get the usual permissions, less the umask
+	perm := os.FileMode(0o666)
+	if existing {
+		perm = info.Mode().Perm()
+	}
+
+	temp, tempPath, err := create_temp_beside(target, perm)
+	if err != nil {
+		return err
+	}
+
+	// Anything going wrong from here just removes the temp file
+	fail := func(err error) error {
+		temp.Close()
+		os.Remove(tempPath)
+		return err
+	}
+
+	if _, err := temp.Write(data); err != nil {
+		return fail(err)
+	}
+	if existing {
+		// Ownership is kept when allowed; a normal user can't give files away.
+		// Chown can clear setuid bits, so the mode is restored after it.
+		copy_owner(temp, info)
+		if err := temp.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)); err != nil {
+			return fail(err)
+		}
+	}
+	if err := temp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := os.Rename(tempPath, target); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	sync_dir(filepath.Dir(target))
+	return nil
+}
+
+// file_writable reports whether path can be saved to. Saving writes a temporary
+// file beside it, so its directory has to be writable as well.
+func file_writable(path string) bool {
+	if resolved, err := resolve_symlinks(path); err == nil {
+		path = resolved
+	}
+	if _, err := os.Stat(path); err == nil && !writable(path) {
+		return false
+	}
+	// A missing directory is offered to be made when saving
+	dir := filepath.Dir(path)
+	return !dir_exists(dir) || writable(dir)
+}
+
+// resolve_symlinks follows path to the file it names, which may not exist yet
+func resolve_symlinks(path string) (string, error) {
+	for range 40 {
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", errors.New(path + ": too many levels of symbolic links")
+}
+
+// create_temp_beside makes a new hidden file in the same directory as target,
+// so renaming it over target never has to cross filesystems
+func create_temp_beside(target string, perm os.FileMode) (*os.File, string, error) {
+	dir, base := filepath.Split(target)
+	prefix := filepath.Join(dir, "."+base+".goatpad-"+strconv.Itoa(os.Getpid())+"-")
+
+	for i := 0; i < SAVE_TEMP_ATTEMPTS; i++ {
+		tempPath := prefix + strconv.Itoa(i) + ".tmp"
+		file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err == nil {
+			return file, tempPath, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", err
+		}
+	}
+	return nil, "", errors.New("could not create a temp file beside " + target)
+}
+
+// sync_dir makes the rename itself durable. Not every platform
+// can sync a directory, so this is best effort.
+func sync_dir(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	file.Sync()
+	file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// writable goes by the owner's write permission, the best that can be done here
+func writable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().Perm()&0o200 != 0
+}
+
+// File ownership isn't carried over outside unix
+func copy_owner(file *os.File, info os.FileInfo) {}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// writable asks the system whether path can be written to
+func writable(path string) bool {
+	return syscall.Access(path, 0x2) == nil // W_OK
+}
+
+// copy_owner gives file the owner and group from info, if allowed
+func copy_owner(file *os.File, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	file.Chown(int(stat.Uid), int(stat.Gid))
+}
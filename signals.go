@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Signals arrive on the UI goroutine through the event loop, like anything else.
+// Hangups and terminations write out unsaved buffers the way a crash does,
+// and suspending hands the terminal back until the editor is continued.
+
+var signals = make(chan os.Signal, 1)
+
+// terminate writes out unsaved buffers and exits, as the editor was told to stop
+func terminate(sig os.Signal) {
+	paths, failures := write_recovery_files()
+	disable_focus_reporting()
+	termbox.Close()
+
+	fmt.Fprintln(os.Stderr, "goatpad: "+sig.String())
+	for _, path := range paths {
+		fmt.Fprintln(os.Stderr, "unsaved changes written to "+path)
+	}
+	for _, failure := range failures {
+		fmt.Fprintln(os.Stderr, "couldn't write unsaved changes: "+failure)
+	}
+
+	// Exit the way a shell reports being killed by the signal
+	code := 1
+	if number, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(number)
+	}
+	os.Exit(code)
+}
+
+// suspend puts the terminal back to normal and stops the editor,
+// picking up where it was once it's continued
+func suspend() {
+	disable_focus_reporting()
+	termbox.Close()
+
+	err := stop_process()
+
+	if err := termbox.Init(); err != nil {
+		panic(err)
+	}
+	enable_focus_reporting()
+	redraw()
+	if err != nil {
+		show_error("Couldn't suspend: " + err.Error())
+	}
+}
+
+// redraw repaints the whole terminal, which may have been drawn over
+func redraw() {
+	resize(termbox.Size())
+	termbox.Sync()
+}
+
+// resize fits the view to the terminal's size, redrawing all of it
+func resize(width int, height int) {
+	// Clearing has termbox take on the new size too, so nothing drawn is cut off.
+	// It empties the screen, so everything is drawn again, size change or not.
+	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	mark_viewport_dirty()
+	statusBar.valid = false
+
+	// -2 rows for the status bar and message line
+	COLS, ROWS = width, height-2
+
+	// status bar errors is there is too little space
+	if COLS < 80 {
+		COLS = 80
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func watch_signals() {
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM)
+}
+
+func handle_signal(sig os.Signal) {
+	terminate(sig)
+}
+
+// There's no job control to stop the editor with outside unix
+func stop_process() error {
+	return errors.New("not supported on this system")
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func watch_signals() {
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGTSTP, syscall.SIGCONT)
+}
+
+func handle_signal(sig os.Signal) {
+	switch sig {
+	case syscall.SIGHUP, syscall.SIGTERM:
+		terminate(sig)
+
+	// A suspend from outside, e.g. kill -TSTP, still gives back the terminal
+	case syscall.SIGTSTP:
+		suspend()
+
+	// Whatever ran while the editor was stopped has drawn over it
+	case syscall.SIGCONT:
+		redraw()
+	}
+}
+
+// stop_process stops the editor until it's continued. SIGTSTP is caught,
+// so SIGSTOP does the stopping, and this returns once continued.
+func stop_process() error {
+	return syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
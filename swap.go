@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// While a file is open, its unsaved text is journaled to a swap file
+// so it can be recovered after a crash. The swap file also records
+// which process has the file open, to warn about editing it twice.
+
+const SWAP_HEADER = "goatpad swap 1"
+
+type swapFile struct {
+	pid      int
+	host     string
+	path     string
+	written  time.Time
+	modified bool
+	format   fileFormatState
+	lines    []string
+}
+
+type swapWrite struct {
+	path string
+	data []byte
+}
+
+var (
+	// Whether open_swap has run for this buffer yet
+	swapChecked bool
+
+	// "" when this buffer isn't being journaled
+	swapPath string
+
+	// A leftover swap file waiting on the recovery prompt,
+	// which must not be overwritten until it's answered
+	swapRecovery *swapFile
+
+	// What was last journaled, to skip writing when nothing changed
+	swapVersion   = -1
+	swapModified  bool
+	swapLastWrite time.Time
+
+	// Writes happen off the UI goroutine, newest first
+	swapQueue  = make(chan swapWrite, 1)
+	swapMutex  sync.Mutex
+	swapClosed bool
+)
+
+func swap_dir() (string, error) {
+	if SWAP_DIR != "" {
+		return SWAP_DIR, nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "goatpad", "swap"), nil
+}
+
+// swap_file_path names the swap file after the whole path of the file,
+// so files with the same name in different directories don't clash
+func swap_file_path(path string) (string, error) {
+	dir, err := swap_dir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.NewReplacer(string(filepath.Separator), "%", ":", "%").Replace(canonical_path(path))
+	return filepath.Join(dir, name+".swp"), nil
+}
+
+// open_swap checks for a leftover swap file for path, then starts journaling to it
+func open_swap(path string) {
+	if swapChecked {
+		return
+	}
+	swapChecked = true
+
+	// Large files can't be edited, so there's nothing to journal,
+	// and pipes and unnamed buffers have no file to name the swap file after
+	if largeFile || pipeMode || path == "" {
+		return
+	}
+
+	swapFilePath, err := swap_file_path(path)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(swapFilePath), 0o700)
+	}
+	if err != nil {
+		show_warning("no swap file, unsaved changes can't be recovered: " + err.Error())
+		return
+	}
+
+	data, err := os.ReadFile(swapFilePath)
+	if err == nil {
+		swap, err := parse_swap(data)
+		switch {
+		case err != nil:
+			show_warning("replacing unreadable swap file " + swapFilePath)
+
+		case swap.pid != os.Getpid() && swap_owner_running(swap):
+			// Leave the other instance's journal alone
+			show_warning(path + " is already open in another goatpad (pid " + strconv.Itoa(swap.pid) + "), changes here aren't journaled")
+			return
+
+		case swap.modified && (swap.format != fileFormat || !slices.Equal(swap.lines, buffer_lines(textBuffer))):
+			swapRecovery = &swap
+			prompt_recovery()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		show_warning("can't read swap file: " + err.Error())
+	}
+
+	swapPath = swapFilePath
+}
+
+func swap_owner_running(swap swapFile) bool {
+	host, _ := os.Hostname()
+	// A process on another machine can't be checked, so assume it died
+	return swap.host == host && process_running(swap.pid)
+}
+
+// ---------- Recovery ----------
+
+func prompt_recovery() {
+	swap := swapRecovery
+	open_prompt("Unsaved changes to "+swap.path+" from "+swap.written.Format("Jan 2 15:04")+" were found:", []promptChoice{
+		{key: 'r', label: "recover", action: recover_swap},
+		{key: 'd', label: "diff", action: diff_swap},
+		{key: 'x', label: "discard", action: discard_swap},
+	})
+}
+
+func recover_swap() {
+	swap := swapRecovery
+	swapRecovery = nil
+
+	textBuffer = make([][]rune, len(swap.lines))
+	for i, line := range swap.lines {
+		textBuffer[i] = appendLineRunes(nil, line)
+	}
+	fileFormat = swap.format
+	go_to_position(position{row: currentRow, col: currentCol})
+	mark_viewport_dirty()
+	mark_line_dirty(currentRow)
+	show_info("recovered unsaved changes, save to keep them")
+}
+
+func diff_swap() {
+	open_pager("swap file changes", diff_lines(buffer_lines(textBuffer), swapRecovery.lines))
+	pagerOnClose = prompt_recovery
+}
+
+func discard_swap() {
+	swapRecovery = nil
+	show_info("discarded the swap file")
+}
+
+// ---------- Journaling ----------
+
+// journal_swap queues the buffer to be written to the swap file,
+// at most once every SWAP_INTERVAL, and only if it has changed
+func journal_swap() {
+	// Swap files only hold text, so the hex view isn't journaled
+	if swapPath == "" || swapRecovery != nil || hexActive {
+		return
+	}
+	if swapVersion == bufferVersion && swapModified == modified {
+		return
+	}
+	if time.Since(swapLastWrite) < SWAP_INTERVAL {
+		return
+	}
+	swapVersion = bufferVersion
+	swapModified = modified
+	swapLastWrite = time.Now()
+
+	// Replace this buffer's write if it hasn't started yet, it's out of date.
+	// Another buffer's has to wait its turn.
+	write := swapWrite{path: swapPath, data: encode_swap()}
+	select {
+	case pending := <-swapQueue:
+		if pending.path != write.path {
+			swapQueue <- pending
+		}
+	default:
+	}
+	swapQueue <- write
+}
+
+func swap_writer() {
+	defer forward_panic()
+	for write := range swapQueue {
+		// Posted after unlocking, as the UI goroutine may be waiting for the lock
+		if err := write_queued_swap(write); err != nil {
+			post(func() { show_warning("writing swap file: " + err.Error()) })
+		}
+	}
+}
+
+// write_queued_swap writes one journal entry, unless the swap files are
+// closed. The lock is deferred so a panic while writing still releases it.
+func write_queued_swap(write swapWrite) error {
+	swapMutex.Lock()
+	defer swapMutex.Unlock()
+	if swapClosed {
+		return nil
+	}
+	return write_swap_file(write.path, write.data)
+}
+
+func write_swap_file(path string, data []byte) error {
+	// Only the user should be able to read their unsaved text
+	temp, err := os.CreateTemp(filepath.Dir(path), ".swap-*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// close_buffer_swap removes the current buffer's swap file,
+// so open_swap can start again under a new name
+func close_buffer_swap() {
+	// A write still queued would bring the file back
+	select {
+	case pending := <-swapQueue:
+		if pending.path != swapPath {
+			swapQueue <- pending
+		}
+	default:
+	}
+
+	swapMutex.Lock()
+	defer swapMutex.Unlock()
+	if swapPath != "" {
+		os.Remove(swapPath)
+	}
+	swapPath = ""
+	swapChecked = false
+	swapRecovery = nil
+	swapVersion = -1
+}
+
+// close_swap removes every buffer's swap file on a clean exit,
+// after any write in progress has finished
+func close_swap() {
+	swapMutex.Lock()
+	defer swapMutex.Unlock()
+	swapClosed = true
+	for _, path := range swap_paths() {
+		os.Remove(path)
+	}
+}
+
+// remove_clean_swaps removes the swap files of buffers with nothing unsaved
+// when exiting abruptly, leaving the rest to be recovered.
+// It's called with swapMutex held.
+func remove_clean_swaps() {
+	for _, b := range buffers {
+		if !b.modified && b.swapPath != "" {
+			os.Remove(b.swapPath)
+		}
+	}
+}
+
+// ---------- Swap File Format ----------
+
+// A header of "key value" lines, a blank line, then the buffer's lines
+func encode_swap() []byte {
+	host, _ := os.Hostname()
+	var data bytes.Buffer
+	data.WriteString(SWAP_HEADER + "\n")
+	data.WriteString("pid " + strconv.Itoa(os.Getpid()) + "\n")
+	data.WriteString("host " + host + "\n")
+	data.WriteString("path " + filename + fileExtension + "\n")
+	data.WriteString("written " + strconv.FormatInt(time.Now().Unix(), 10) + "\n")
+	data.WriteString("modified " + strconv.FormatBool(modified) + "\n")
+	data.WriteString("lineending " + strings.ToLower(line_ending_name(fileFormat.lineEnding)) + "\n")
+	data.WriteString("finalnewline " + strconv.FormatBool(fileFormat.finalNewline) + "\n")
+	data.WriteString("bom " + strconv.FormatBool(fileFormat.bom) + "\n")
+	data.WriteString("encoding " + fileFormat.encoding + "\n")
+	data.WriteString("\n")
+	for row, line := range textBuffer {
+		if row > 0 {
+			data.WriteString("\n")
+		}
+		data.Write(append_line_bytes(nil, line))
+	}
+	return data.Bytes()
+}
+
+func parse_swap(data []byte) (swapFile, error) {
+	swap := swapFile{format: new_file_format()}
+
+	header, rest, found := strings.Cut(string(data), "\n")
+	if !found || header != SWAP_HEADER {
+		return swap, errors.New("not a swap file")
+	}
+
+	for {
+		var line string
+		line, rest, found = strings.Cut(rest, "\n")
+		if !found {
+			return swap, errors.New("swap file is cut short")
+		}
+		if line == "" {
+			break
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "pid":
+			swap.pid, _ = strconv.Atoi(value)
+		case "host":
+			swap.host = value
+		case "path":
+			swap.path = value
+		case "written":
+			seconds, _ := strconv.ParseInt(value, 10, 64)
+			swap.written = time.Unix(seconds, 0)
+		case "modified":
+			swap.modified, _ = strconv.ParseBool(value)
+		case "lineending":
+			if lineEnding, ok := parse_line_ending(value); ok {
+				swap.format.lineEnding = lineEnding
+			}
+		case "finalnewline":
+			swap.format.finalNewline, _ = strconv.ParseBool(value)
+		case "bom":
+			swap.format.bom, _ = strconv.ParseBool(value)
+		case "encoding":
+			if encoding, ok := parse_encoding(value); ok {
+				swap.format.encoding = encoding
+			}
+		}
+	}
+
+	swap.lines = strings.Split(rest, "\n")
+	return swap, nil
+}
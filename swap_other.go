@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// process_running checks for pid, which only fails here if it has exited
+func process_running(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// Highlighting colours each row by the tokens a language's tokenizer finds in it.
+// Rows are highlighted as they're drawn, in order from the top, since a row can
+// start inside something an earlier row opened, like a block comment. What each
+// row gave is kept, and only rows that are dirty, or that now start differently,
+// are tokenized again. Far down a long file, rows just above the view stand in
+// for the rest until they've been caught up on, a bit each redraw.
+
+// ---------- Languages ----------
+
+// A kind of token, each drawn in its own colour
+type syntaxClass int
+
+const (
+	SYNTAX_NONE syntaxClass = iota
+	SYNTAX_KEYWORD
+	SYNTAX_TYPE
+	SYNTAX_CONSTANT
+	SYNTAX_NUMBER
+	SYNTAX_STRING
+	SYNTAX_COMMENT
+	SYNTAX_SPECIAL
+)
+
+// The class names used in syntax files
+var syntaxClassNames = map[string]syntaxClass{
+	"keyword":  SYNTAX_KEYWORD,
+	"type":     SYNTAX_TYPE,
+	"constant": SYNTAX_CONSTANT,
+	"number":   SYNTAX_NUMBER,
+	"string":   SYNTAX_STRING,
+	"comment":  SYNTAX_COMMENT,
+	"special":  SYNTAX_SPECIAL,
+}
+
+func syntax_color(class syntaxClass) termbox.Attribute {
+	switch class {
+	case SYNTAX_KEYWORD:
+		return SYNTAX_KEYWORD_FG
+	case SYNTAX_TYPE:
+		return SYNTAX_TYPE_FG
+	case SYNTAX_CONSTANT:
+		return SYNTAX_CONSTANT_FG
+	case SYNTAX_NUMBER:
+		return SYNTAX_NUMBER_FG
+	case SYNTAX_STRING:
+		return SYNTAX_STRING_FG
+	case SYNTAX_COMMENT:
+		return SYNTAX_COMMENT_FG
+	case SYNTAX_SPECIAL:
+		return SYNTAX_SPECIAL_FG
+	}
+	return termbox.ColorDefault
+}
+
+// A span of a row's runes, from start up to end
+type syntaxSpan struct {
+	start int
+	end   int
+	class syntaxClass
+}
+
+// A tokenizer finds the spans in one row. state is what the row starts inside,
+// 0 for nothing, and it returns what the next row starts inside.
+type tokenizer interface {
+	tokenize(line []rune, state int) (spans []syntaxSpan, endState int)
+}
+
+type syntaxLanguage struct {
+	name       string
+	extensions []string // e.g. ".go"
+	files      []string // whole file names, for files without an extension
+	tokenizer  tokenizer
+}
+
+var (
+	languages       []*syntaxLanguage
+	languagesLoaded bool
+)
+
+// register_language adds a language, replacing any with the same name
+func register_language(lang *syntaxLanguage) {
+	for i, other := range languages {
+		if other.name == lang.name {
+			languages[i] = lang
+			return
+		}
+	}
+	languages = append(languages, lang)
+}
+
+// find_language looks a language up by name
+func find_language(name string) *syntaxLanguage {
+	load_languages()
+	for _, lang := range languages {
+		if lang.name == name {
+			return lang
+		}
+	}
+	return nil
+}
+
+// language_for_file picks a language by the file's extension or name
+func language_for_file(name string, extension string) *syntaxLanguage {
+	load_languages()
+	base := filepath.Base(name + extension)
+	for _, lang := range languages {
+		if extension != "" && slices.ContainsFunc(lang.extensions, func(ext string) bool { return strings.EqualFold(ext, extension) }) {
+			return lang
+		}
+		if slices.Contains(lang.files, base) {
+			return lang
+		}
+	}
+	return nil
+}
+
+func language_names() []string {
+	load_languages()
+	names := make([]string, len(languages))
+	for i, lang := range languages {
+		names[i] = lang.name
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ---------- Syntax Files ----------
+
+// The built-in grammars. Files in goatpad/syntax in the user config directory
+// are read after these, so they can add languages or replace one by its name.
+//
+//go:embed syntax/*.syntax
+var builtinSyntax embed.FS
+
+// load_languages reads the syntax files the first time a language is needed
+func load_languages() {
+	if languagesLoaded {
+		return
+	}
+	languagesLoaded = true
+
+	paths, _ := fs.Glob(builtinSyntax, "syntax/*.syntax")
+	for _, path := range paths {
+		file, _ := builtinSyntax.Open(path)
+		lang, err := read_syntax_file(file)
+		file.Close()
+		if err != nil {
+			panic(path + ": " + err.Error())
+		}
+		register_language(lang)
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return
+	}
+	paths, _ = filepath.Glob(filepath.Join(dir, "goatpad", "syntax", "*.syntax"))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			show_warning(err.Error())
+			continue
+		}
+		lang, err := read_syntax_file(file)
+		file.Close()
+		if err != nil {
+			show_warning(path + ": " + err.Error())
+			continue
+		}
+		register_language(lang)
+	}
+}
+
+// read_syntax_file reads a grammar, one setting or rule per line:
+//
+//	name go
+//	extensions .go
+//	files go.work
+//	keyword \b(?:if|else|for)\b
+//	region comment /\* \*/
+//
+// A rule is a class and a pattern. A region is a class and a start and end
+// pattern, and can run over several lines. Patterns can't hold spaces, use \s.
+// Blank lines and lines starting with # are skipped.
+func read_syntax_file(file fs.File) (*syntaxLanguage, error) {
+	lang := &syntaxLanguage{}
+	grammar := &regexGrammar{}
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		key, args := fields[0], fields[1:]
+
+		var err error
+		switch key {
+		case "name":
+			if len(args) != 1 {
+				err = errors.New("name takes one word")
+			} else {
+				lang.name = args[0]
+			}
+		case "extensions":
+			lang.extensions = append(lang.extensions, args...)
+		case "files":
+			lang.files = append(lang.files, args...)
+		case "region":
+			err = grammar.add_region(args)
+		default:
+			err = grammar.add_rule(key, args)
+		}
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(lineNum) + ": " + err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lang.name == "" {
+		return nil, errors.New("no name given")
+	}
+	if err := grammar.compile(); err != nil {
+		return nil, err
+	}
+	lang.tokenizer = grammar
+	return lang, nil
+}
+
+// ---------- Regex Grammars ----------
+
+type grammarRule struct {
+	class   syntaxClass
+	pattern *regexp.Regexp
+	end     *regexp.Regexp // only regions have an end
+}
+
+// A regexGrammar tokenizes with the rules from a syntax file. Its state is
+// the region a row starts inside, counting from 1.
+type regexGrammar struct {
+	rules []grammarRule
+
+	// Every rule's pattern as one alternative, so one pass over a line
+	// finds its tokens, and the group each rule's match is in
+	combined *regexp.Regexp
+	groups   []int
+}
+
+func (g *regexGrammar) add_rule(className string, args []string) error {
+	class, ok := syntaxClassNames[className]
+	if !ok {
+		return errors.New("unknown class or setting " + className)
+	}
+	if len(args) != 1 {
+		return errors.New(className + " takes one pattern")
+	}
+	pattern, err := regexp.Compile(args[0])
+	if err != nil {
+		return err
+	}
+	g.rules = append(g.rules, grammarRule{class: class, pattern: pattern})
+	return nil
+}
+
+func (g *regexGrammar) add_region(args []string) error {
+	if len(args) != 3 {
+		return errors.New("region takes a class, a start and an end pattern")
+	}
+	class, ok := syntaxClassNames[args[0]]
+	if !ok {
+		return errors.New("unknown class " + args[0])
+	}
+	start, err := regexp.Compile(args[1])
+	if err != nil {
+		return err
+	}
+	end, err := regexp.Compile(args[2])
+	if err != nil {
+		return err
+	}
+	g.rules = append(g.rules, grammarRule{class: class, pattern: start, end: end})
+	return nil
+}
+
+// compile joins the rules up once they've all been read
+func (g *regexGrammar) compile() error {
+	alternatives := make([]string, len(g.rules))
+	g.groups = make([]int, len(g.rules))
+	group := 1
+	for i, rule := range g.rules {
+		alternatives[i] = "(" + rule.pattern.String() + ")"
+		g.groups[i] = group
+		group += 1 + rule.pattern.NumSubexp()
+	}
+	combined, err := regexp.Compile(strings.Join(alternatives, "|"))
+	g.combined = combined
+	return err
+}
+
+// tokenize takes the earliest match of any rule as the next token, the first
+// listed winning a tie, then carries on from its end. Patterns are matched
+// against the whole line, so ^ and \b only see the line's real start and
+// word edges, not where the last token ended.
+func (g *regexGrammar) tokenize(line []rune, state int) ([]syntaxSpan, int) {
+	text := string(line)
+
+	// The rune column of each byte offset that starts a rune,
+	// which is everywhere a match can start or end
+	cols := make([]int, len(text)+1)
+	col := 0
+	for offset := range text {
+		cols[offset] = col
+		col++
+	}
+	cols[len(text)] = col
+
+	var spans []syntaxSpan
+	add_span := func(start int, end int, class syntaxClass) {
+		if end > start {
+			spans = append(spans, syntaxSpan{start: cols[start], end: cols[end], class: class})
+		}
+	}
+
+	pos := 0
+	if state > 0 && state <= len(g.rules) {
+		rule := g.rules[state-1]
+		end := find_after(rule.end, text, 0)
+		if end == nil {
+			add_span(0, len(text), rule.class)
+			return spans, state
+		}
+		add_span(0, end[1], rule.class)
+		pos = end[1]
+	}
+	if len(g.rules) == 0 {
+		return spans, 0
+	}
+
+	// Matches before pos were inside a region, and are passed over
+	matches := g.combined.FindAllStringSubmatchIndex(text, -1)
+	for i := 0; i < len(matches); i++ {
+		match := matches[i]
+		if match[0] < pos {
+			if match[1] > pos {
+				// It runs out of a region, so what follows is matched on its own
+				matches = offset_matches(g.combined.FindAllStringSubmatchIndex(text[pos:], -1), pos)
+				i = -1
+			}
+			continue
+		}
+
+		rule := 0
+		for match[2*g.groups[rule]] < 0 {
+			rule++
+		}
+		class, end := g.rules[rule].class, g.rules[rule].end
+		if end == nil {
+			add_span(match[0], match[1], class)
+			pos = match[1]
+			continue
+		}
+
+		regionEnd := find_after(end, text, match[1])
+		if regionEnd == nil {
+			add_span(match[0], len(text), class)
+			return spans, rule + 1
+		}
+		add_span(match[0], regionEnd[1], class)
+		pos = regionEnd[1]
+	}
+	return spans, 0
+}
+
+// find_after finds the first match in text starting at or after pos,
+// matching the whole line like tokenize does
+func find_after(pattern *regexp.Regexp, text string, pos int) []int {
+	for _, match := range pattern.FindAllStringIndex(text, -1) {
+		switch {
+		case match[0] >= pos:
+			return match
+		case match[1] > pos:
+			// One that runs across pos hides any after it, so look again from there
+			return offset_matches([][]int{pattern.FindStringIndex(text[pos:])}, pos)[0]
+		}
+	}
+	return nil
+}
+
+// offset_matches moves match indexes found in text[pos:] to where they are in text
+func offset_matches(matches [][]int, pos int) [][]int {
+	for _, match := range matches {
+		for i := range match {
+			if match[i] >= 0 {
+				match[i] += pos
+			}
+		}
+	}
+	return matches
+}
+
+// ---------- Highlighting Rows ----------
+
+// How many rows are tokenized on the way down to the view before jumping
+// to this far above it, so a long file is caught up on a bit at a time
+const SYNTAX_SYNC_ROWS = 1000
+
+// What a row was highlighted from, and what it gave
+type syntaxRow struct {
+	hash     uint64
+	state    int
+	endState int
+	spans    []syntaxSpan
+	valid    bool
+}
+
+var (
+	syntaxRows    []syntaxRow
+	syntaxChecked int             // rows before this match what they were highlighted from
+	syntaxVersion int             // bufferVersion when last highlighted
+	syntaxLang    *syntaxLanguage // what syntaxRows came from
+
+	// Set with :syntax, "" to go by the file name
+	syntaxChoice string
+)
+
+// buffer_language is the language the buffer is highlighted as, if any
+func buffer_language() *syntaxLanguage {
+	switch {
+	case largeFile, hexActive, syntaxChoice == "off":
+		return nil
+	case syntaxChoice != "":
+		return find_language(syntaxChoice)
+	case !SYNTAX_HIGHLIGHTING:
+		return nil
+	}
+	return language_for_file(filename, fileExtension)
+}
+
+// update_highlighting brings the rows up to the bottom of the view up to date,
+// marking dirty the ones that changed colour
+func update_highlighting() {
+	lang := buffer_language()
+	if lang != syntaxLang {
+		syntaxRows, syntaxChecked, syntaxLang = nil, 0, lang
+		mark_viewport_dirty()
+	}
+	if lang == nil {
+		return
+	}
+
+	// Edits that move rows around redraw the view, and could have changed any row
+	if viewportDirty && bufferVersion != syntaxVersion {
+		syntaxChecked = 0
+	}
+	syntaxVersion = bufferVersion
+	for row := 0; row < syntaxChecked && row < len(dirtyRows); row++ {
+		if dirtyRows[row] {
+			syntaxChecked = row
+			break
+		}
+	}
+
+	if len(syntaxRows) > len(textBuffer) {
+		syntaxRows = syntaxRows[:len(textBuffer)]
+	} else {
+		syntaxRows = append(syntaxRows, make([]syntaxRow, len(textBuffer)-len(syntaxRows))...)
+	}
+
+	last := min(offsetRow+ROWS, len(textBuffer))
+	checked := last
+	tokenized := 0
+	syncRow := -1
+	for row := syntaxChecked; row < last; row++ {
+		// With a lot left to tokenize, jump to a little above the view and start
+		// as if nothing was open there. Later frames carry on from here.
+		if syncRow < 0 && tokenized >= SYNTAX_SYNC_ROWS && offsetRow-row > SYNTAX_SYNC_ROWS {
+			checked = row
+			syncRow = offsetRow - SYNTAX_SYNC_ROWS
+			row = syncRow
+		}
+
+		state := 0
+		if row > 0 && row != syncRow {
+			state = syntaxRows[row-1].endState
+		}
+		hash := hash_line(textBuffer[row])
+		cached := &syntaxRows[row]
+		if cached.valid && cached.hash == hash && cached.state == state {
+			continue
+		}
+
+		// Rows above the view are drawn afresh when scrolled back to
+		spans, endState := lang.tokenizer.tokenize(textBuffer[row], state)
+		tokenized++
+		if row >= offsetRow && row < len(dirtyRows) && !slices.Equal(spans, cached.spans) {
+			dirtyRows[row] = true
+		}
+		*cached = syntaxRow{hash: hash, state: state, endState: endState, spans: spans, valid: true}
+	}
+	syntaxChecked = max(syntaxChecked, checked)
+}
+
+// row_spans is how a row is highlighted, nil if it isn't
+func row_spans(row int) []syntaxSpan {
+	if syntaxLang == nil || row < 0 || row >= len(syntaxRows) {
+		return nil
+	}
+	return syntaxRows[row].spans
+}
+
+// hash_line is FNV-1a over the runes, to tell when a row has changed
+func hash_line(line []rune) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, r := range line {
+		hash ^= uint64(r)
+		hash *= 1099511628211
+	}
+	return hash
+}
+
+// ---------- Commands ----------
+
+// :syntax shows the buffer's language, or picks one by name,
+// "off" to turn highlighting off, or "auto" to go by the file name
+func cmd_syntax(args []string) error {
+	if len(args) == 0 {
+		lang := buffer_language()
+		switch {
+		case lang != nil:
+			show_info("syntax: " + lang.name)
+		case syntaxChoice == "off":
+			show_info("syntax: off")
+		default:
+			show_info("syntax: none (have " + strings.Join(language_names(), ", ") + ")")
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return errors.New("usage: syntax [name|off|auto]")
+	}
+
+	switch name := args[0]; {
+	case name == "auto":
+		syntaxChoice = ""
+	case name == "off":
+		syntaxChoice = name
+	case find_language(name) != nil:
+		syntaxChoice = name
+	default:
+		return errors.New("unknown syntax " + name + " (have " + strings.Join(language_names(), ", ") + ")")
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// render_spans writes a row's spans as class:text, to compare them easily
+func render_spans(line []rune, spans []syntaxSpan) []string {
+	var rendered []string
+	for _, span := range spans {
+		name := ""
+		for className, class := range syntaxClassNames {
+			if class == span.class {
+				name = className
+			}
+		}
+		rendered = append(rendered, name+":"+string(line[span.start:span.end]))
+	}
+	return rendered
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		rows []string
+		want [][]string // each row's spans
+	}{
+		{
+			name: "comment after a string holding //",
+			lang: "go",
+			rows: []string{`s := "a//b" // c`},
+			want: [][]string{{`string:"a//b"`, `comment:// c`}},
+		},
+		{
+			name: "block comment across rows",
+			lang: "go",
+			rows: []string{"x := 1 /* a", "b", "c */ return x"},
+			want: [][]string{
+				{"number:1", "comment:/* a"},
+				{"comment:b"},
+				{"comment:c */", "keyword:return"},
+			},
+		},
+		{
+			name: "raw string across rows",
+			lang: "go",
+			rows: []string{"s := `a", "// b", "c` + len(s)"},
+			want: [][]string{
+				{"string:`a"},
+				{"string:// b"},
+				{"string:c`", "special:len"},
+			},
+		},
+		{
+			name: "triple quoted string across rows",
+			lang: "python",
+			rows: []string{`x = """a # b`, `c"""  # d`},
+			want: [][]string{
+				{`string:"""a # b`},
+				{`string:c"""`, "comment:# d"},
+			},
+		},
+		{
+			name: "two regions on a row",
+			lang: "go",
+			rows: []string{"/* a */ x /* b", "*/ `c", "d`"},
+			want: [][]string{
+				{"comment:/* a */", "comment:/* b"},
+				{"comment:*/", "string:`c"},
+				{"string:d`"},
+			},
+		},
+		{
+			name: "^ only matches at the start of the row",
+			lang: "c",
+			rows: []string{`#define S(x) "<" #x`, "/* a", "b */#x"},
+			want: [][]string{
+				{"special:#define", `string:"<"`},
+				{"comment:/* a"},
+				{"comment:b */"},
+			},
+		},
+		{
+			name: "^ doesn't match where a region ended",
+			lang: "python",
+			rows: []string{`x = """a`, `b"""@c`, `  @c`},
+			want: [][]string{
+				{`string:"""a`},
+				{`string:b"""`},
+				{"special:  @c"},
+			},
+		},
+		{
+			name: "\\s before a comment isn't the end of a string",
+			lang: "shell",
+			rows: []string{`echo "a # b"#c`, `echo a # c`},
+			want: [][]string{{`string:"a # b"`}, {"comment: # c"}},
+		},
+	}
+
+	for _, test := range tests {
+		lang := find_language(test.lang)
+		if lang == nil {
+			t.Fatalf("%s: no %s language", test.name, test.lang)
+		}
+		state := 0
+		for row, text := range test.rows {
+			line := []rune(text)
+			var spans []syntaxSpan
+			spans, state = lang.tokenizer.tokenize(line, state)
+			if got := render_spans(line, spans); !slices.Equal(got, test.want[row]) {
+				t.Errorf("%s: row %d = %q, want %q", test.name, row, got, test.want[row])
+			}
+		}
+		if state != 0 {
+			t.Errorf("%s: ends in state %d, want 0", test.name, state)
+		}
+	}
+}
+
+func TestHighlightingCatchUp(t *testing.T) {
+	// A comment opened on the first row and closed near the end
+	rowCount := 3*SYNTAX_SYNC_ROWS + 100
+	textBuffer = make([][]rune, rowCount)
+	for row := range textBuffer {
+		textBuffer[row] = []rune(fmt.Sprintf("x%d", row))
+	}
+	textBuffer[0] = []rune("/*")
+	textBuffer[rowCount-10] = []rune("*/")
+	syntaxChoice, syntaxLang, syntaxRows, syntaxChecked = "go", nil, nil, 0
+	ROWS, offsetRow = 40, rowCount-40
+	dirtyRows = nil
+	sync_dirty_rows()
+	defer func() {
+		textBuffer, dirtyRows, syntaxChoice, syntaxLang, syntaxRows, syntaxChecked = nil, nil, "", nil, nil, 0
+	}()
+
+	comment := func(row int) bool {
+		spans := row_spans(row)
+		return len(spans) == 1 && spans[0].class == SYNTAX_COMMENT
+	}
+
+	// The first frame jumps ahead, so the view starts outside the comment
+	update_highlighting()
+	if comment(offsetRow) {
+		t.Fatalf("first frame: row %d was caught up on already", offsetRow)
+	}
+	if syntaxChecked >= rowCount {
+		t.Fatalf("first frame: checked %d rows of %d", syntaxChecked, rowCount)
+	}
+
+	frames := 1
+	for syntaxChecked < len(textBuffer) {
+		clear(dirtyRows)
+		viewportDirty = false
+		update_highlighting()
+		if frames++; frames > rowCount/SYNTAX_SYNC_ROWS+2 {
+			t.Fatalf("not caught up after %d frames, checked %d rows", frames, syntaxChecked)
+		}
+	}
+
+	// Caught up, the view knows it's inside the comment until it closes
+	for row := offsetRow; row < rowCount-10; row++ {
+		if !comment(row) {
+			t.Fatalf("row %d = %v, want a comment", row, row_spans(row))
+		}
+	}
+	if got := render_spans(textBuffer[rowCount-10], row_spans(rowCount-10)); !slices.Equal(got, []string{"comment:*/"}) {
+		t.Errorf("closing row = %q", got)
+	}
+	if spans := row_spans(rowCount - 1); len(spans) != 0 {
+		t.Errorf("row after the comment = %q", strings.Join(render_spans(textBuffer[rowCount-1], spans), " "))
+	}
+	if !dirtyRows[offsetRow] {
+		t.Errorf("view row %d wasn't redrawn when caught up on", offsetRow)
+	}
+}
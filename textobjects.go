@@ -0,0 +1,492 @@
+package main
+
+import "unicode"
+
+// textRange is a span of the text buffer selected by a text object.
+// Charwise ranges run from start up to (but not including) end,
+// linewise ranges cover every row from start.row to end.row.
+type textRange struct {
+	start    position
+	end      position
+	linewise bool
+}
+
+// ---------- Buffer Positions ----------
+
+func clamp_position(pos position) position {
+	if pos.row < 0 {
+		pos.row = 0
+	}
+	if pos.row >= len(textBuffer) {
+		pos.row = len(textBuffer) - 1
+	}
+	if pos.col < 0 {
+		pos.col = 0
+	}
+	if pos.col > len(textBuffer[pos.row]) {
+		pos.col = len(textBuffer[pos.row])
+	}
+	return pos
+}
+
+func position_before(a position, b position) bool {
+	return a.row < b.row || (a.row == b.row && a.col < b.col)
+}
+
+// rune_at_position treats the end of every line as a '\n'
+func rune_at_position(pos position) rune {
+	line := textBuffer[pos.row]
+	if pos.col >= len(line) {
+		return '\n'
+	}
+	return line[pos.col]
+}
+
+func next_position(pos position) (position, bool) {
+	if pos.col < len(textBuffer[pos.row]) {
+		return position{row: pos.row, col: pos.col + 1}, true
+	}
+	if pos.row < len(textBuffer)-1 {
+		return position{row: pos.row + 1, col: 0}, true
+	}
+	return pos, false
+}
+
+func prev_position(pos position) (position, bool) {
+	if pos.col > 0 {
+		return position{row: pos.row, col: pos.col - 1}, true
+	}
+	if pos.row > 0 {
+		return position{row: pos.row - 1, col: len(textBuffer[pos.row-1])}, true
+	}
+	return pos, false
+}
+
+func is_blank_line(row int) bool {
+	for _, ch := range textBuffer[row] {
+		if !unicode.IsSpace(ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// ---------- Text Object Selection ----------
+
+func find_text_object(scope rune, object rune) (textRange, bool) {
+	around := scope == AROUND_OBJECT
+
+	switch object {
+	case '"', '\'', '`':
+		return find_quote_object(object, around)
+	case '(', ')':
+		return find_bracket_object('(', ')', around)
+	case '[', ']':
+		return find_bracket_object('[', ']', around)
+	case '{', '}':
+		return find_bracket_object('{', '}', around)
+	case '<', '>':
+		return find_bracket_object('<', '>', around)
+	case PARAGRAPH_OBJECT:
+		return find_paragraph_object(around)
+	case ARGUMENT_OBJECT:
+		return find_argument_object(around)
+	}
+	return textRange{}, false
+}
+
+func find_quote_object(quote rune, around bool) (textRange, bool) {
+	// Quotes are paired left to right along the current line,
+	// skipping any that are escaped with a backslash
+	line := textBuffer[currentRow]
+	quotes := make([]int, 0, 8)
+	for col := 0; col < len(line); col++ {
+		if line[col] == '\\' {
+			col++
+			continue
+		}
+		if line[col] == quote {
+			quotes = append(quotes, col)
+		}
+	}
+
+	// Use the pair surrounding the cursor, or the first pair after it
+	open, close := -1, -1
+	for i := 0; i+1 < len(quotes); i += 2 {
+		if currentCol <= quotes[i+1] {
+			open, close = quotes[i], quotes[i+1]
+			break
+		}
+	}
+	if open == -1 {
+		return textRange{}, false
+	}
+
+	if !around {
+		return textRange{
+			start: position{row: currentRow, col: open + 1},
+			end:   position{row: currentRow, col: close},
+		}, true
+	}
+
+	// Around also takes the whitespace that follows the closing quote
+	end := close + 1
+	for end < len(line) && (line[end] == ' ' || line[end] == '\t') {
+		end++
+	}
+	return textRange{
+		start: position{row: currentRow, col: open},
+		end:   position{row: currentRow, col: end},
+	}, true
+}
+
+func find_bracket_object(open rune, close rune, around bool) (textRange, bool) {
+	cursor := clamp_position(position{row: currentRow, col: currentCol})
+
+	var start, end position
+	var found bool
+	switch rune_at_position(cursor) {
+	case open:
+		start = cursor
+		end, found = find_matching_close(open, close, cursor)
+	case close:
+		end = cursor
+		start, found = find_matching_open(open, close, cursor)
+	default:
+		start, found = find_matching_open(open, close, cursor)
+		if found {
+			end, found = find_matching_close(open, close, start)
+		}
+	}
+	if !found {
+		return textRange{}, false
+	}
+
+	if around {
+		return textRange{start: start, end: position{row: end.row, col: end.col + 1}}, true
+	}
+
+	// A bracket that ends its line, closed on a line of its own,
+	// selects the lines in between rather than the newlines around them
+	if start.row != end.row && start.col == len(textBuffer[start.row])-1 && is_indent_before(end) {
+		if end.row-start.row < 2 {
+			return textRange{start: end, end: end}, true
+		}
+		return textRange{
+			start:    position{row: start.row + 1},
+			end:      position{row: end.row - 1},
+			linewise: true,
+		}, true
+	}
+	return textRange{start: position{row: start.row, col: start.col + 1}, end: end}, true
+}
+
+func is_indent_before(pos position) bool {
+	for _, ch := range textBuffer[pos.row][:pos.col] {
+		if ch != ' ' && ch != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+func find_matching_open(open rune, close rune, from position) (position, bool) {
+	depth := 0
+	pos, ok := prev_position(from)
+	for ; ok; pos, ok = prev_position(pos) {
+		switch rune_at_position(pos) {
+		case close:
+			depth++
+		case open:
+			if depth == 0 {
+				return pos, true
+			}
+			depth--
+		}
+	}
+	return from, false
+}
+
+func find_matching_close(open rune, close rune, from position) (position, bool) {
+	depth := 0
+	pos, ok := next_position(from)
+	for ; ok; pos, ok = next_position(pos) {
+		switch rune_at_position(pos) {
+		case open:
+			depth++
+		case close:
+			if depth == 0 {
+				return pos, true
+			}
+			depth--
+		}
+	}
+	return from, false
+}
+
+func find_paragraph_object(around bool) (textRange, bool) {
+	// A paragraph is a run of non-blank lines (or of blank lines,
+	// if the cursor sits on one), separated by blank lines
+	blank := is_blank_line(currentRow)
+
+	first := currentRow
+	for first > 0 && is_blank_line(first-1) == blank {
+		first--
+	}
+	last := currentRow
+	for last < len(textBuffer)-1 && is_blank_line(last+1) == blank {
+		last++
+	}
+
+	if around {
+		// Take the separating lines that follow, or the ones before if at the end
+		if last < len(textBuffer)-1 {
+			for last < len(textBuffer)-1 && is_blank_line(last+1) != blank {
+				last++
+			}
+		} else {
+			for first > 0 && is_blank_line(first-1) != blank {
+				first--
+			}
+		}
+	}
+
+	return textRange{start: position{row: first}, end: position{row: last}, linewise: true}, true
+}
+
+func find_argument_object(around bool) (textRange, bool) {
+	cursor := clamp_position(position{row: currentRow, col: currentCol})
+
+	// Find the innermost bracket pair holding the cursor
+	open, ok := cursor, false
+	if ch := rune_at_position(cursor); ch == '(' || ch == '[' || ch == '{' {
+		open, ok = cursor, true
+	} else {
+		depth := 0
+		pos, more := prev_position(cursor)
+		for ; more; pos, more = prev_position(pos) {
+			switch rune_at_position(pos) {
+			case ')', ']', '}':
+				depth++
+			case '(', '[', '{':
+				if depth == 0 {
+					open, ok = pos, true
+				}
+				depth--
+			}
+			if ok {
+				break
+			}
+		}
+	}
+	if !ok {
+		return textRange{}, false
+	}
+
+	// Split the bracket contents on top-level commas,
+	// ignoring any inside nested brackets or quotes
+	separators := []position{open}
+	depth := 0
+	var quote rune
+	pos, more := next_position(open)
+	for ; more; pos, more = next_position(pos) {
+		ch := rune_at_position(pos)
+		if quote != 0 {
+			if ch == '\\' {
+				pos, more = next_position(pos)
+				if !more {
+					break
+				}
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '"', '\'', '`':
+			quote = ch
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			if depth == 0 {
+				separators = append(separators, pos)
+				more = false
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				separators = append(separators, pos)
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	if len(separators) < 2 {
+		return textRange{}, false
+	}
+
+	// Pick the argument the cursor is on
+	index := len(separators) - 2
+	for i := 0; i+1 < len(separators); i++ {
+		if !position_before(separators[i+1], cursor) {
+			index = i
+			break
+		}
+	}
+
+	argStart, _ := next_position(separators[index])
+	argEnd := separators[index+1]
+	innerStart, innerEnd := trim_range_whitespace(argStart, argEnd)
+
+	if !around {
+		return textRange{start: innerStart, end: innerEnd}, true
+	}
+
+	if index+2 < len(separators) {
+		// Take the comma after the argument, up to the next argument
+		nextStart, _ := next_position(separators[index+1])
+		nextInner, _ := trim_range_whitespace(nextStart, separators[index+2])
+		return textRange{start: innerStart, end: nextInner}, true
+	}
+	if index > 0 {
+		// Last argument, so take the comma before it instead
+		return textRange{start: separators[index], end: innerEnd}, true
+	}
+	return textRange{start: innerStart, end: innerEnd}, true
+}
+
+func trim_range_whitespace(start position, end position) (position, position) {
+	for position_before(start, end) && unicode.IsSpace(rune_at_position(start)) {
+		start, _ = next_position(start)
+	}
+	for position_before(start, end) {
+		prev, _ := prev_position(end)
+		if !unicode.IsSpace(rune_at_position(prev)) {
+			break
+		}
+		end = prev
+	}
+	return start, end
+}
+
+// ---------- Text Object Editing ----------
+
+func range_text(r textRange) [][]rune {
+	if r.linewise {
+		lines := make([][]rune, r.end.row-r.start.row+1)
+		for i := range lines {
+			lines[i] = make([]rune, len(textBuffer[r.start.row+i]))
+			copy(lines[i], textBuffer[r.start.row+i])
+		}
+		return lines
+	}
+
+	lines := make([][]rune, r.end.row-r.start.row+1)
+	for i := range lines {
+		row := r.start.row + i
+		left, right := 0, len(textBuffer[row])
+		if row == r.start.row {
+			left = r.start.col
+		}
+		if row == r.end.row {
+			right = r.end.col
+		}
+		lines[i] = make([]rune, right-left)
+		copy(lines[i], textBuffer[row][left:right])
+	}
+	return lines
+}
+
+func copy_range(r textRange) {
+	copyBuffer.contents = range_text(r)
+	if r.linewise {
+		copyBuffer.bufferType = "block"
+	} else {
+		copyBuffer.bufferType = "symbol"
+	}
+}
+
+func delete_range(r textRange) {
+	if r.linewise {
+		textBuffer = append(textBuffer[:r.start.row], textBuffer[r.end.row+1:]...)
+		delete_tracked_rows(r.start.row, r.end.row)
+		if len(textBuffer) == 0 {
+			textBuffer = append(textBuffer, []rune{})
+		}
+		currentRow = r.start.row
+		if currentRow >= len(textBuffer) {
+			currentRow = len(textBuffer) - 1
+		}
+		currentCol = 0
+	} else {
+		startLine := textBuffer[r.start.row]
+		endLine := textBuffer[r.end.row]
+		joined := make([]rune, r.start.col+len(endLine)-r.end.col)
+		copy(joined[:r.start.col], startLine[:r.start.col])
+		copy(joined[r.start.col:], endLine[r.end.col:])
+
+		textBuffer[r.start.row] = joined
+		textBuffer = append(textBuffer[:r.start.row+1], textBuffer[r.end.row+1:]...)
+		delete_tracked_range(r.start, r.end)
+		currentRow = r.start.row
+		currentCol = r.start.col
+	}
+
+	mark_viewport_dirty()
+	mark_line_dirty(currentRow)
+}
+
+func change_range(r textRange) {
+	if r.linewise {
+		// Replace the lines with a single empty one, keeping the indentation
+		firstLine := textBuffer[r.start.row]
+		indentLen := 0
+		for indentLen < len(firstLine) && (firstLine[indentLen] == ' ' || firstLine[indentLen] == '\t') {
+			indentLen++
+		}
+		indent := make([]rune, indentLen)
+		copy(indent, firstLine[:indentLen])
+
+		delete_range(textRange{start: r.start, end: r.end, linewise: true})
+		textBuffer = append(textBuffer[:r.start.row], append([][]rune{indent}, textBuffer[r.start.row:]...)...)
+		shift_tracked_positions(position{row: r.start.row, col: 0}, 1, 0)
+		currentRow = r.start.row
+		currentCol = indentLen
+		mark_line_dirty(currentRow)
+	} else {
+		delete_range(r)
+	}
+	switch_mode("Insert")
+}
+
+// insert_text places lines at the cursor, splitting the current line
+// around them, and leaves the cursor after the inserted text
+func insert_text(lines [][]rune) {
+	currentLine := textBuffer[currentRow]
+	head := currentLine[:currentCol]
+	tail := currentLine[currentCol:]
+
+	newLines := make([][]rune, len(lines))
+	for i, line := range lines {
+		newLine := make([]rune, 0, len(line)+len(head)+len(tail))
+		if i == 0 {
+			newLine = append(newLine, head...)
+		}
+		newLine = append(newLine, line...)
+		newLines[i] = newLine
+	}
+	last := len(newLines) - 1
+	endCol := len(newLines[last])
+	newLines[last] = append(newLines[last], tail...)
+
+	textBuffer = append(textBuffer[:currentRow], append(newLines, textBuffer[currentRow+1:]...)...)
+	shift_tracked_positions(position{row: currentRow, col: currentCol}, last, endCol-currentCol)
+	currentRow += last
+	currentCol = endCol
+
+	if last > 0 {
+		mark_viewport_dirty()
+	}
+	mark_line_dirty(currentRow)
+}